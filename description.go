@@ -0,0 +1,50 @@
+package stats
+
+// Description describes a family of metrics.
+type Description struct {
+	Help string // the help text
+	Unit Unit   // the unit of values
+}
+
+// Describer is implemented by the MetricsSnapshot knowing the descriptions
+// of metrics, e.g. the Store.
+type Describer interface {
+	// Description returns the description of the metrics with the given tag
+	// extracted name.
+	Description(name string) (Description, bool)
+}
+
+var _ Describer = new(Store)
+
+// Describe attaches the help text and unit to the metrics of the given name
+// within the scope namespace. The metrics with tags extracted from the name
+// share the description, and the later one overrides the former.
+func (scope *Scope) Describe(name, help string, unit Unit) {
+	extractedName, _ := scope.store.getTagsForName(scope.prefix + name)
+	scope.store.describe(extractedName, Description{Help: help, Unit: unit})
+}
+
+func (store *Store) describe(name string, desc Description) {
+	store.descsLock.Lock()
+	defer store.descsLock.Unlock()
+
+	descs := store.loadDescriptions()
+	tmp := make(map[string]Description, len(descs)+1)
+	for k, v := range descs {
+		tmp[k] = v
+	}
+	tmp[name] = desc
+	store.descs.Store(tmp)
+}
+
+func (store *Store) loadDescriptions() map[string]Description {
+	descs, _ := store.descs.Load().(map[string]Description)
+	return descs
+}
+
+// Description returns the description of the metrics with the given tag
+// extracted name.
+func (store *Store) Description(name string) (Description, bool) {
+	desc, ok := store.loadDescriptions()[name]
+	return desc, ok
+}
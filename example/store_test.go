@@ -2,6 +2,7 @@ package example
 
 import (
 	"context"
+	"time"
 
 	"github.com/kirk91/stats"
 	"github.com/kirk91/stats/sink/statsd"
@@ -52,6 +53,11 @@ func ExampleStore() {
 		close(loopDone)
 	}()
 	defer func() {
+		// flush the metrics of the last interval before exiting.
+		ctx, cancelClose := context.WithTimeout(context.TODO(), time.Second)
+		store.Close(ctx)
+		cancelClose()
+
 		cancel()
 		<-loopDone
 	}()
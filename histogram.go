@@ -2,9 +2,13 @@ package stats
 
 import (
 	"fmt"
+	"math"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	hist "github.com/samaritan-proxy/circonusllhist"
 )
@@ -36,7 +40,7 @@ type HistogramStatistics struct {
 	cbs []uint64  // computed
 }
 
-func newHistogramStatistics(h *hist.Histogram) *HistogramStatistics {
+func newHistogramStatistics(h *hist.Histogram, quantiles, buckets []float64) *HistogramStatistics {
 	s := &HistogramStatistics{
 		Histogram:   h,
 		sampleCount: h.SampleCount(),
@@ -44,11 +48,11 @@ func newHistogramStatistics(h *hist.Histogram) *HistogramStatistics {
 	}
 
 	// quantiles
-	s.sqs = defaultSupportedQuantiles
+	s.sqs = quantiles
 	s.cqs, _ = h.ApproxQuantile(s.sqs)
 
 	// buckets
-	s.sbs = defaultSupportedBuckets
+	s.sbs = buckets
 	for _, b := range s.sbs {
 		s.cbs = append(s.cbs, h.ApproxCountBelow(b))
 	}
@@ -97,64 +101,313 @@ type Histogram struct {
 	metric
 	store *Store
 
-	sampleCount uint64
-	rawCount    uint64
-	raws        []*hist.Histogram
+	quantiles []float64
+	buckets   []float64
+	unit      Unit
+
+	// countAndHotIdx holds the number of Records started in its lower 63
+	// bits, and the index of the hot buffer which the Records go to in its
+	// highest bit. The buffers are swapped to drain the cold one losslessly.
+	countAndHotIdx uint64
+	bufs           [2]*histogramBuffer
+	rawCount       uint64
+
+	mu          sync.Mutex                      // guards the following
+	lastStarted uint64                          // the number of Records started before the last swap
+	pending     map[*latchState]*hist.Histogram // the pending interval hists of consumers
+	itl         *hist.Histogram                 // interval hist
+	cum         *hist.Histogram                 // cumulative hist
+	window      time.Duration                   // the max duration of slots retained
+	slots       []windowSlot                    // the interval hists within window, oldest first
+}
+
+// windowSlot is the interval hist refreshed at the time.
+type windowSlot struct {
+	at  time.Time
+	itl *hist.Histogram
+}
 
-	itl *hist.Histogram // interval hist
-	cum *hist.Histogram // cumulative hist
+// histogramBuffer holds the samples recorded since it became hot.
+type histogramBuffer struct {
+	count uint64 // the number of Records completed
+	raws  []*hist.Histogram
+}
+
+func newHistogramBuffer(rawCount uint64) *histogramBuffer {
+	buf := &histogramBuffer{raws: make([]*hist.Histogram, rawCount)}
+	for i := uint64(0); i < rawCount; i++ {
+		buf.raws[i] = hist.New()
+	}
+	return buf
 }
 
 // NewHistogram creates a histogram with given params.
 // NOTE: It should only be used in unit tests.
 func NewHistogram(store *Store, name, tagExtractedName string, tags []*Tag) *Histogram {
+	return newHistogram(store, newMetric(name, tagExtractedName, tags), HistogramOptions{})
+}
+
+func newHistogram(store *Store, m metric, o HistogramOptions) *Histogram {
 	h := &Histogram{
-		store:    store,
-		metric:   newMetric(name, tagExtractedName, tags),
-		itl:      hist.NewNoLocks(),
-		cum:      hist.New(),
-		rawCount: uint64(runtime.GOMAXPROCS(0)),
-	}
-	h.raws = make([]*hist.Histogram, h.rawCount)
-	for i := uint64(0); i < h.rawCount; i++ {
-		h.raws[i] = hist.New()
+		store:     store,
+		metric:    m,
+		quantiles: o.quantiles(),
+		buckets:   o.buckets(),
+		unit:      o.Unit,
+		window:    storeHistogramWindow(store),
+		pending:   make(map[*latchState]*hist.Histogram),
+		itl:       hist.NewNoLocks(),
+		cum:       hist.New(),
+		rawCount:  uint64(runtime.GOMAXPROCS(0)),
 	}
+	h.bufs[0] = newHistogramBuffer(h.rawCount)
+	h.bufs[1] = newHistogramBuffer(h.rawCount)
 	return h
 }
 
 // Record records a value to the Histogram.
 func (h *Histogram) Record(val uint64) {
-	raw := h.raws[atomic.AddUint64(&h.sampleCount, 1)%h.rawCount]
+	if h.null {
+		return
+	}
+	raw, buf := h.hotRaw()
 	raw.RecordIntScale(int64(val), 0)
+	atomic.AddUint64(&buf.count, 1)
+	if h.store != nil {
+		h.store.deliverHistogramSampleToSinks(h, histogramSample{val: val})
+	}
+	h.markUsed()
+}
+
+// RecordFloat records a float value to the Histogram, e.g. 0.3ms latency.
+func (h *Histogram) RecordFloat(val float64) {
+	if h.null {
+		return
+	}
+	raw, buf := h.hotRaw()
+	raw.RecordValue(val) //nolint:errcheck
+	atomic.AddUint64(&buf.count, 1)
 	if h.store != nil {
-		h.store.deliverHistogramSampleToSinks(h, val)
+		h.store.deliverHistogramSampleToSinks(h, histogramSample{fval: val, isFloat: true})
 	}
 	h.markUsed()
 }
 
+// RecordScaled records the value of val*10^scale to the Histogram without
+// precision loss, e.g. RecordScaled(75, -2) records 0.75.
+func (h *Histogram) RecordScaled(val int64, scale int) {
+	if h.null {
+		return
+	}
+	raw, buf := h.hotRaw()
+	raw.RecordIntScale(val, scale) //nolint:errcheck
+	atomic.AddUint64(&buf.count, 1)
+	if h.store != nil {
+		fval := float64(val) * math.Pow10(scale)
+		h.store.deliverHistogramSampleToSinks(h, histogramSample{fval: fval, isFloat: true})
+	}
+	h.markUsed()
+}
+
+// hotRaw returns a raw hist of the hot buffer to record to, the count of
+// buffer must be increased once the recording done.
+func (h *Histogram) hotRaw() (*hist.Histogram, *histogramBuffer) {
+	n := atomic.AddUint64(&h.countAndHotIdx, 1)
+	buf := h.bufs[n>>63]
+	return buf.raws[n%h.rawCount], buf
+}
+
+// RecordDuration records the duration to the Histogram in its unit, the
+// histograms without time unit record it in milliseconds.
+func (h *Histogram) RecordDuration(d time.Duration) {
+	h.RecordFloat(h.unit.FromDuration(d))
+}
+
 // RefreshIntervalStatistic refreshs the interval statistics of histogram.
 // NOTE: It should only be used in unit tests.
 func (h *Histogram) RefreshIntervalStatistics() {
-	// merge and reset all raw hists
+	itl := h.takeInterval(nil)
+	h.mu.Lock()
+	h.itl = itl
+	h.pushSlotLocked(time.Now(), itl)
+	h.mu.Unlock()
+}
+
+func storeHistogramWindow(store *Store) time.Duration {
+	if store == nil {
+		return 0
+	}
+	return store.histWindow
+}
+
+// pushSlotLocked appends the interval hist to the window, and drops the
+// slots out of the window.
+func (h *Histogram) pushSlotLocked(now time.Time, itl *hist.Histogram) {
+	if h.window <= 0 {
+		return
+	}
+	h.slots = append(h.slots, windowSlot{at: now, itl: itl})
+	i := 0
+	for i < len(h.slots) && now.Sub(h.slots[i].at) >= h.window {
+		i++
+	}
+	if i > 0 {
+		h.slots = append(h.slots[:0], h.slots[i:]...)
+	}
+}
+
+// windowSlots returns a copy of the slots within window, the hists of which
+// are never modified once pushed.
+func (h *Histogram) windowSlots() []windowSlot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.slots) == 0 {
+		return nil
+	}
+	slots := make([]windowSlot, len(h.slots))
+	copy(slots, h.slots)
+	return slots
+}
+
+// WindowStatistics returns the statistics of the samples refreshed within
+// the last d, it's made up of the interval statistics of the flush intervals
+// ended within d. So d is capped by the HistogramWindow of store, and should
+// be a multiple of the flush interval.
+func (h *Histogram) WindowStatistics(d time.Duration) *HistogramStatistics {
+	return h.windowStatistics(time.Now(), d)
+}
+
+func (h *Histogram) windowStatistics(now time.Time, d time.Duration) *HistogramStatistics {
 	merged := hist.NewNoLocks()
-	for _, raw := range h.raws {
+	h.mu.Lock()
+	for i := len(h.slots) - 1; i >= 0; i-- {
+		if now.Sub(h.slots[i].at) >= d {
+			break
+		}
+		merged.Merge(h.slots[i].itl)
+	}
+	h.mu.Unlock()
+	return h.statistics(merged)
+}
+
+// takeInterval returns the hist of samples recorded since the last take of
+// the given consumer, the nil consumer represents the histogram itself.
+func (h *Histogram) takeInterval(consumer *latchState) *hist.Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	merged := h.drainLocked()
+	itl, ok := h.pending[consumer]
+	if !ok {
+		itl = merged
+	}
+	h.pending[consumer] = hist.NewNoLocks()
+	return itl
+}
+
+// forgetConsumer removes the pending interval hist of the consumer, which
+// takes no more intervals.
+func (h *Histogram) forgetConsumer(consumer *latchState) {
+	h.mu.Lock()
+	delete(h.pending, consumer)
+	h.mu.Unlock()
+}
+
+// drainLocked merges the raw hists into the cumulative and pending ones,
+// and returns the samples drained.
+func (h *Histogram) drainLocked() *hist.Histogram {
+	// swap the hot and cold buffers, then wait for the Records started
+	// on the cold one to complete, so that no sample is lost.
+	n := atomic.AddUint64(&h.countAndHotIdx, 1<<63)
+	started := n & (1<<63 - 1)
+	cold := h.bufs[(^n)>>63]
+	for atomic.LoadUint64(&cold.count) != started-h.lastStarted {
+		runtime.Gosched()
+	}
+	h.lastStarted = started
+
+	// the cold buffer is quiescent now, merge and reset all its raw hists.
+	merged := hist.NewNoLocks()
+	for _, raw := range cold.raws {
 		merged.Merge(raw)
-		// NOTE: Merge and reset is not atomic, maybe some
-		// samples would be dropped.
 		raw.FullReset()
 	}
-	h.itl = merged.Copy()
+	atomic.StoreUint64(&cold.count, 0)
 	h.cum.Merge(merged)
+	for _, p := range h.pending {
+		p.Merge(merged)
+	}
+	return merged
 }
 
 // IntervalStatistics returns the interval statistics of Histogram.
 func (h *Histogram) IntervalStatistics() *HistogramStatistics {
-	return newHistogramStatistics(h.itl.Copy())
+	h.mu.Lock()
+	itl := h.itl.Copy()
+	h.mu.Unlock()
+	return h.statistics(itl)
 }
 
 // CumulativeStatistics returns the cumulative statistics of Histogram.
 func (h *Histogram) CumulativeStatistics() *HistogramStatistics {
-	return newHistogramStatistics(h.cum.Copy())
+	return h.statistics(h.cum.Copy())
+}
+
+// SupportedQuantiles returns the supported quantiles, which mustn't be
+// modified.
+func (h *Histogram) SupportedQuantiles() []float64 {
+	return h.quantiles
+}
+
+// SupportedBuckets returns the supported buckets, which mustn't be modified.
+func (h *Histogram) SupportedBuckets() []float64 {
+	return h.buckets
+}
+
+// AppendIntervalQuantiles appends the computed values of the supported
+// quantiles during the last interval to values. Unlike IntervalStatistics,
+// the interval hist isn't copied.
+func (h *Histogram) AppendIntervalQuantiles(values []float64) []float64 {
+	h.mu.Lock()
+	cqs, _ := h.itl.ApproxQuantile(h.quantiles)
+	h.mu.Unlock()
+	if len(cqs) == 0 {
+		// no samples
+		for range h.quantiles {
+			values = append(values, 0)
+		}
+		return values
+	}
+	return append(values, cqs...)
+}
+
+// AppendCumulativeBuckets appends the cumulative counts of the supported
+// buckets to counts, and returns them with the cumulative sample count and
+// sum. Unlike CumulativeStatistics, the cumulative hist isn't copied, which
+// makes it cheap to expose the histograms.
+func (h *Histogram) AppendCumulativeBuckets(counts []uint64) ([]uint64, uint64, float64) {
+	for _, b := range h.buckets {
+		counts = append(counts, h.cum.ApproxCountBelow(b))
+	}
+	// the samples may be merged meanwhile, the count taken after the
+	// buckets is never less than them.
+	count, sum := h.CumulativeCountAndSum()
+	return counts, count, sum
+}
+
+// CumulativeCountAndSum returns the cumulative sample count and sum without
+// copying the cumulative hist.
+func (h *Histogram) CumulativeCountAndSum() (uint64, float64) {
+	return h.cum.SampleCount(), h.cum.ApproxSum()
+}
+
+func (h *Histogram) statistics(hh *hist.Histogram) *HistogramStatistics {
+	return newHistogramStatistics(hh, h.quantiles, h.buckets)
+}
+
+// Unit returns the unit of the recorded values.
+func (h *Histogram) Unit() Unit {
+	return h.unit
 }
 
 // Summary returns the summary of the histogram.
@@ -162,17 +415,36 @@ func (h *Histogram) Summary() string {
 	if h.cum.SampleCount() == 0 {
 		return "No recorded values"
 	}
+	return summarize(h.IntervalStatistics(), h.statistics(h.cum))
+}
+
+// WindowSummary returns the summary of the histogram like Summary, but the
+// window statistics of the last d are presented instead of the interval ones.
+func (h *Histogram) WindowSummary(d time.Duration) string {
+	if h.window <= 0 {
+		return "No window retained"
+	}
+	if h.cum.SampleCount() == 0 {
+		return "No recorded values"
+	}
+	return summarize(h.WindowStatistics(d), h.statistics(h.cum))
+}
 
-	itlStat := newHistogramStatistics(h.itl)
-	cumStat := newHistogramStatistics(h.cum)
+func summarize(itlStat, cumStat *HistogramStatistics) string {
 	var summary []string
 	for i, q := range cumStat.SupportedQuantiles() {
 		summary = append(summary,
-			fmt.Sprintf("P%d(%d,%d)", int(100*q),
-				int64(itlStat.ComputedQuantiles()[i]),
-				int64(cumStat.ComputedQuantiles()[i]),
+			fmt.Sprintf("P%s(%s,%s)", formatFloat(100*q),
+				formatFloat(itlStat.ComputedQuantiles()[i]),
+				formatFloat(cumStat.ComputedQuantiles()[i]),
 			),
 		)
 	}
 	return strings.Join(summary, " ")
 }
+
+// formatFloat formats the value rounded to 4 decimal places, e.g. 99.9 for
+// 99.89999999999999 and 800 for 800.0000001.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e4)/1e4, 'f', -1, 64)
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// HistogramOptions contains options of a Histogram, the zero value means
+// the default quantiles and buckets without unit.
+type HistogramOptions struct {
+	// Buckets are the upper bounds of the buckets, none of them could be
+	// NaN. The duplicates and infinities are dropped, as the +Inf bucket is
+	// always implied.
+	Buckets []float64
+	// Quantiles are the quantiles computed, each one must be within [0, 1].
+	Quantiles []float64
+	// Unit is the unit of the recorded values.
+	Unit Unit
+}
+
+// buckets returns the sorted copy of buckets without the duplicates and
+// infinities, or the default ones if unset.
+func (o *HistogramOptions) buckets() []float64 {
+	if len(o.Buckets) == 0 {
+		return defaultSupportedBuckets
+	}
+	buckets := sortedCopy(o.Buckets)
+	n := 0
+	for _, b := range buckets {
+		if !math.IsInf(b, 0) {
+			buckets[n] = b
+			n++
+		}
+	}
+	return buckets[:n]
+}
+
+// validate panics if any quantile is not within [0, 1] or any bucket is NaN.
+func (o *HistogramOptions) validate() {
+	for _, q := range o.Quantiles {
+		// NaN fails the comparison as well
+		if !(q >= 0 && q <= 1) {
+			panic("HistogramOptions needs quantiles within [0, 1]")
+		}
+	}
+	for _, b := range o.Buckets {
+		if math.IsNaN(b) {
+			panic("HistogramOptions needs buckets other than NaN")
+		}
+	}
+}
+
+// quantiles returns the sorted copy of quantiles, or the default ones if unset.
+func (o *HistogramOptions) quantiles() []float64 {
+	if len(o.Quantiles) == 0 {
+		return defaultSupportedQuantiles
+	}
+	return sortedCopy(o.Quantiles)
+}
+
+// sortedCopy returns the sorted copy of vals without the duplicates.
+func sortedCopy(vals []float64) []float64 {
+	tmp := make([]float64, len(vals))
+	copy(tmp, vals)
+	sort.Float64s(tmp)
+	n := 0
+	for i, v := range tmp {
+		if i == 0 || v != tmp[n-1] {
+			tmp[n] = v
+			n++
+		}
+	}
+	return tmp[:n]
+}
+
+// LinearBuckets returns count buckets, each width wide, the lowest one's
+// upper bound is start. It panics if count is less than 1.
+func LinearBuckets(start, width float64, count int) []float64 {
+	if count < 1 {
+		panic("LinearBuckets needs a positive count")
+	}
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start += width
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count buckets, the lowest one's upper bound is
+// start and each following one's is factor times the previous. It panics if
+// count is less than 1, start is not positive or factor is not greater than 1.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	if count < 1 {
+		panic("ExponentialBuckets needs a positive count")
+	}
+	if start <= 0 {
+		panic("ExponentialBuckets needs a positive start value")
+	}
+	if factor <= 1 {
+		panic("ExponentialBuckets needs a factor greater than 1")
+	}
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinearBuckets(t *testing.T) {
+	assert.Equal(t, []float64{1, 3, 5, 7}, LinearBuckets(1, 2, 4))
+	assert.Panics(t, func() { LinearBuckets(1, 2, 0) })
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	assert.Equal(t, []float64{1, 2, 4, 8}, ExponentialBuckets(1, 2, 4))
+	assert.Panics(t, func() { ExponentialBuckets(1, 2, 0) })
+	assert.Panics(t, func() { ExponentialBuckets(0, 2, 4) })
+	assert.Panics(t, func() { ExponentialBuckets(1, 1, 4) })
+}
+
+func TestHistogramOptionsQuantiles(t *testing.T) {
+	o := HistogramOptions{Quantiles: []float64{0.99, 0, 1, 0.5, 0.5}}
+	assert.Equal(t, []float64{0, 0.5, 0.99, 1}, o.quantiles())
+	assert.Equal(t, defaultSupportedQuantiles, (&HistogramOptions{}).quantiles())
+
+	for _, q := range []float64{-0.1, 1.5, math.NaN(), math.Inf(1)} {
+		o := HistogramOptions{Quantiles: []float64{0.5, q}}
+		assert.Panics(t, func() { o.validate() })
+	}
+}
+
+func TestHistogramOptionsBuckets(t *testing.T) {
+	o := HistogramOptions{Buckets: []float64{5, 1, math.Inf(1), 1, math.Inf(-1), 0.5}}
+	assert.Equal(t, []float64{0.5, 1, 5}, o.buckets())
+	assert.Equal(t, defaultSupportedBuckets, (&HistogramOptions{}).buckets())
+
+	o = HistogramOptions{Buckets: []float64{1, math.NaN()}}
+	assert.Panics(t, func() { o.validate() })
+}
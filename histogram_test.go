@@ -1,7 +1,9 @@
 package stats
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	hist "github.com/samaritan-proxy/circonusllhist"
 	"github.com/stretchr/testify/assert"
@@ -12,7 +14,7 @@ func TestHistogramStatistic(t *testing.T) {
 	for i := 2; i < 100; i++ {
 		hist.RecordIntScale(int64(i), 0)
 	}
-	s := newHistogramStatistics(hist)
+	s := newHistogramStatistics(hist, defaultSupportedQuantiles, defaultSupportedBuckets)
 	assert.EqualValues(t, 98, s.SampleCount())
 	assert.NotZero(t, s.SampleSum())
 	assert.Equal(t, defaultSupportedQuantiles, s.SupportedQuantiles())
@@ -58,3 +60,105 @@ func TestHistogram(t *testing.T) {
 	assert.Contains(t, h.Summary(), "P99")
 	assert.Contains(t, h.Summary(), "P100")
 }
+
+func TestHistogramAppendStatistics(t *testing.T) {
+	h := NewHistogram(nil, "foo.bar", "foo", nil)
+	assert.Equal(t, make([]float64, len(defaultSupportedQuantiles)), h.AppendIntervalQuantiles(nil))
+
+	for i := 2; i < 100; i++ {
+		h.Record(uint64(i))
+	}
+	h.RefreshIntervalStatistics()
+	itlStat := h.IntervalStatistics()
+	cumStat := h.CumulativeStatistics()
+
+	assert.Equal(t, defaultSupportedQuantiles, h.SupportedQuantiles())
+	assert.Equal(t, defaultSupportedBuckets, h.SupportedBuckets())
+	assert.Equal(t, itlStat.ComputedQuantiles(), h.AppendIntervalQuantiles(nil))
+	counts, count, sum := h.AppendCumulativeBuckets([]uint64{42})
+	assert.Equal(t, append([]uint64{42}, cumStat.ComputedBuckets()...), counts)
+	assert.Equal(t, cumStat.SampleCount(), count)
+	assert.Equal(t, cumStat.SampleSum(), sum)
+}
+
+func TestHistogramConcurrentRefresh(t *testing.T) {
+	const (
+		goroutines = 8
+		records    = 20000
+	)
+	h := NewHistogram(nil, "foo.bar", "foo", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				h.Record(uint64(j % 100))
+			}
+		}()
+	}
+
+	// refresh continuously while recording
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var total uint64
+	for {
+		h.RefreshIntervalStatistics()
+		total += h.IntervalStatistics().SampleCount()
+		select {
+		case <-done:
+			h.RefreshIntervalStatistics()
+			total += h.IntervalStatistics().SampleCount()
+			assert.Equal(t, uint64(goroutines*records), total)
+			assert.Equal(t, uint64(goroutines*records), h.CumulativeStatistics().SampleCount())
+			return
+		default:
+		}
+	}
+}
+
+func TestHistogramRecordFloat(t *testing.T) {
+	h := NewHistogram(nil, "foo.bar", "foo", nil)
+	h.RecordFloat(0.3)
+	h.RecordScaled(75, -2)
+	h.RefreshIntervalStatistics()
+
+	s := h.IntervalStatistics()
+	assert.Equal(t, uint64(2), s.SampleCount())
+	assert.InDelta(t, 1.05, s.SampleSum(), 0.02)
+	assert.Equal(t, uint64(1), s.ApproxCountBelow(0.5))
+	assert.Contains(t, h.Summary(), "P0(0.3")
+}
+
+func TestHistogramWindowStatistics(t *testing.T) {
+	h := NewHistogram(nil, "foo.bar", "foo", nil)
+	h.window = time.Minute * 5
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		h.RecordFloat(1)
+		itl := h.takeInterval(nil)
+		h.mu.Lock()
+		h.pushSlotLocked(now.Add(time.Minute*time.Duration(i)), itl)
+		h.mu.Unlock()
+	}
+	// the slots out of window are dropped
+	assert.Equal(t, 5, len(h.slots))
+
+	now = now.Add(time.Minute * 9)
+	assert.Equal(t, uint64(1), h.windowStatistics(now, time.Minute).SampleCount())
+	assert.Equal(t, uint64(3), h.windowStatistics(now, time.Minute*3).SampleCount())
+	assert.Equal(t, uint64(5), h.windowStatistics(now, time.Hour).SampleCount())
+	assert.Equal(t, uint64(10), h.CumulativeStatistics().SampleCount())
+
+	// no window retained
+	h = NewHistogram(nil, "foo.bar", "foo", nil)
+	h.Record(1)
+	h.RefreshIntervalStatistics()
+	assert.Equal(t, uint64(0), h.WindowStatistics(time.Minute).SampleCount())
+	assert.Equal(t, "No window retained", h.WindowSummary(time.Minute))
+}
@@ -0,0 +1,478 @@
+package http
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kirk91/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// textChecker checks the exposition against the grammar of the classic
+// text format or OpenMetrics.
+type textChecker struct {
+	openMetrics bool
+
+	family   string // the current family
+	typ      string
+	samples  bool                // whether the current family has samples
+	families map[string]struct{} // the seen families
+	metadata map[string]struct{} // the seen metadata of the current family
+	series   map[string]struct{} // the seen series
+	buckets  map[string]*bucketState
+}
+
+type bucketState struct {
+	le     float64
+	count  float64
+	inf    float64
+	hasInf bool
+}
+
+func newTextChecker(openMetrics bool) *textChecker {
+	return &textChecker{
+		openMetrics: openMetrics,
+		families:    make(map[string]struct{}),
+		series:      make(map[string]struct{}),
+	}
+}
+
+func (c *textChecker) check(text string) error {
+	if !strings.HasSuffix(text, "\n") {
+		return fmt.Errorf("no trailing line feed")
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if c.openMetrics {
+		if lines[len(lines)-1] != "# EOF" {
+			return fmt.Errorf("no # EOF at the end")
+		}
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		var err error
+		if strings.HasPrefix(line, "#") {
+			err = c.checkComment(line)
+		} else {
+			err = c.checkSample(line)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d %q: %v", i+1, line, err)
+		}
+	}
+	return nil
+}
+
+func (c *textChecker) startFamily(name string) error {
+	if name == c.family {
+		return nil
+	}
+	if _, ok := c.families[name]; ok {
+		return fmt.Errorf("family %s is interleaved", name)
+	}
+	c.families[name] = struct{}{}
+	c.family, c.typ, c.samples = name, "", false
+	c.metadata = make(map[string]struct{})
+	c.buckets = make(map[string]*bucketState)
+	return nil
+}
+
+func (c *textChecker) checkComment(line string) error {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 || parts[0] != "#" {
+		if c.openMetrics {
+			return fmt.Errorf("invalid comment")
+		}
+		return nil
+	}
+	keyword, name := parts[1], parts[2]
+	switch keyword {
+	case "HELP", "TYPE", "UNIT":
+	default:
+		if c.openMetrics {
+			return fmt.Errorf("unknown keyword %s", keyword)
+		}
+		return nil
+	}
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name %s", name)
+	}
+	if err := c.startFamily(name); err != nil {
+		return err
+	}
+	if c.samples {
+		return fmt.Errorf("%s after samples", keyword)
+	}
+	if _, ok := c.metadata[keyword]; ok {
+		return fmt.Errorf("duplicate %s", keyword)
+	}
+	c.metadata[keyword] = struct{}{}
+
+	arg := ""
+	if len(parts) == 4 {
+		arg = parts[3]
+	}
+	switch keyword {
+	case "HELP":
+		escapes := `\n`
+		if c.openMetrics {
+			escapes = `\n"`
+		}
+		return checkEscapes(arg, escapes)
+	case "TYPE":
+		types := "counter gauge histogram summary untyped"
+		if c.openMetrics {
+			types = "counter gauge histogram gaugehistogram stateset info summary unknown"
+		}
+		for _, typ := range strings.Split(types, " ") {
+			if arg == typ {
+				c.typ = typ
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid type %s", arg)
+	default: // UNIT
+		if c.openMetrics && !strings.HasSuffix(name, "_"+arg) {
+			return fmt.Errorf("unit %s isn't the suffix", arg)
+		}
+	}
+	return nil
+}
+
+// checkEscapes checks that the backslash is only followed by the escapable
+// chars.
+func checkEscapes(s, escapable string) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 == len(s) || !strings.ContainsRune(`\`+escapable, rune(s[i+1])) {
+			return fmt.Errorf("invalid escape")
+		}
+		i++
+	}
+	return nil
+}
+
+// sampleSuffixes returns the allowed suffixes of the sample names by type.
+func (c *textChecker) sampleSuffixes() []string {
+	switch c.typ {
+	case "counter":
+		if c.openMetrics {
+			return []string{"_total", "_created"}
+		}
+		return []string{""}
+	case "histogram":
+		if c.openMetrics {
+			return []string{"_bucket", "_sum", "_count", "_created"}
+		}
+		return []string{"_bucket", "_sum", "_count"}
+	case "summary":
+		if c.openMetrics {
+			return []string{"", "_sum", "_count", "_created"}
+		}
+		return []string{"", "_sum", "_count"}
+	default:
+		return []string{""}
+	}
+}
+
+func (c *textChecker) checkSample(line string) error {
+	nameEnd := strings.IndexAny(line, "{ ")
+	if nameEnd <= 0 {
+		return fmt.Errorf("no value")
+	}
+	name := line[:nameEnd]
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name %s", name)
+	}
+	if c.typ == "" {
+		// untyped samples make their own family
+		if err := c.startFamily(name); err != nil {
+			return err
+		}
+	}
+	suffix := ""
+	for _, s := range c.sampleSuffixes() {
+		if name == c.family+s {
+			suffix = s
+			break
+		}
+	}
+	if name != c.family+suffix {
+		if _, ok := c.families[name]; ok {
+			return fmt.Errorf("family %s is interleaved", name)
+		}
+		return fmt.Errorf("sample %s doesn't belong to family %s", name, c.family)
+	}
+	c.samples = true
+
+	rest := line[nameEnd:]
+	labels := make(map[string]string)
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		if rest, err = parseLabels(rest[1:], labels, c.openMetrics); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(rest, " ") {
+		return fmt.Errorf("no space before value")
+	}
+	fields := strings.Split(rest[1:], " ")
+	if len(fields) > 2 {
+		return fmt.Errorf("trailing garbage")
+	}
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return err
+	}
+	if len(fields) == 2 {
+		if _, err := strconv.ParseFloat(fields[1], 64); err != nil {
+			return fmt.Errorf("invalid timestamp %s", fields[1])
+		}
+	}
+
+	key := seriesKey(name, labels)
+	if _, ok := c.series[key]; ok {
+		return fmt.Errorf("duplicate series")
+	}
+	c.series[key] = struct{}{}
+	return c.checkValue(suffix, labels, value)
+}
+
+// checkValue checks the semantics of values, e.g. the cumulative buckets.
+func (c *textChecker) checkValue(suffix string, labels map[string]string, value float64) error {
+	switch {
+	case c.typ == "counter" && value < 0:
+		return fmt.Errorf("negative counter")
+	case c.typ == "summary" && suffix == "":
+		if _, ok := labels["quantile"]; !ok {
+			return fmt.Errorf("no quantile label")
+		}
+	case c.typ == "histogram" && suffix == "_bucket":
+		leStr, ok := labels["le"]
+		if !ok {
+			return fmt.Errorf("no le label")
+		}
+		le, err := parseValue(leStr)
+		if err != nil {
+			return err
+		}
+		delete(labels, "le")
+		key := seriesKey("", labels)
+		state, ok := c.buckets[key]
+		if !ok {
+			state = &bucketState{le: -1e308}
+			c.buckets[key] = state
+		}
+		if le <= state.le || value < state.count {
+			return fmt.Errorf("buckets are not cumulative")
+		}
+		state.le, state.count = le, value
+		if leStr == "+Inf" {
+			state.hasInf, state.inf = true, value
+		}
+	case c.typ == "histogram" && suffix == "_count":
+		state, ok := c.buckets[seriesKey("", labels)]
+		if !ok || !state.hasInf {
+			return fmt.Errorf("no +Inf bucket")
+		}
+		if state.inf != value {
+			return fmt.Errorf("count mismatches the +Inf bucket")
+		}
+	}
+	return nil
+}
+
+func parseLabels(s string, labels map[string]string, openMetrics bool) (string, error) {
+	for {
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			return "", fmt.Errorf("invalid labels")
+		}
+		name := s[:eq]
+		if !labelNameRE.MatchString(name) {
+			return "", fmt.Errorf("invalid label name %s", name)
+		}
+		if _, ok := labels[name]; ok {
+			return "", fmt.Errorf("duplicate label %s", name)
+		}
+		s = s[eq+1:]
+		if !strings.HasPrefix(s, `"`) {
+			return "", fmt.Errorf("unquoted label value")
+		}
+		s = s[1:]
+		var value strings.Builder
+		for {
+			if s == "" {
+				return "", fmt.Errorf("unterminated label value")
+			}
+			ch := s[0]
+			if ch == '"' {
+				s = s[1:]
+				break
+			}
+			if ch == '\\' {
+				if len(s) < 2 || !strings.ContainsRune(`\"n`, rune(s[1])) {
+					return "", fmt.Errorf("invalid escape in label value")
+				}
+				value.WriteByte(s[1])
+				s = s[2:]
+				continue
+			}
+			if ch == '\n' {
+				return "", fmt.Errorf("line feed in label value")
+			}
+			value.WriteByte(ch)
+			s = s[1:]
+		}
+		labels[name] = value.String()
+		switch {
+		case strings.HasPrefix(s, ",}") && openMetrics:
+			return "", fmt.Errorf("trailing comma")
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case strings.HasPrefix(s, "}"):
+		default:
+			return "", fmt.Errorf("invalid labels")
+		}
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "-Inf", "NaN":
+		v, _ := strconv.ParseFloat(s, 64)
+		return v, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", s)
+	}
+	return v, nil
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func TestTextChecker(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"# TYPE a gauge\na 1\n", ""},
+		{"# TYPE a gauge\na 1\n# TYPE b gauge\nb 1\na{x=\"1\"} 1\n", "interleaved"},
+		{"# TYPE a gauge\na{x=\"a\"b\"} 1\n", "invalid labels"},
+		{"# TYPE a gauge\na{x=\"1\",x=\"2\"} 1\n", "duplicate label"},
+		{"# TYPE a gauge\na 1\na 2\n", "duplicate series"},
+		{"# TYPE a gauge\na{1x=\"1\"} 1\n", "invalid label name"},
+		{"# TYPE a gauge\na{x=\"\\t\"} 1\n", "invalid escape"},
+		{"# TYPE a counter\na_total 1\n", "doesn't belong"},
+		{"# TYPE a histogram\na_bucket{le=\"1\"} 2\na_bucket{le=\"+Inf\"} 1\na_count 1\n", "not cumulative"},
+		{"# TYPE a histogram\na_bucket{le=\"1\"} 1\na_count 1\n", "no +Inf"},
+		{"# TYPE a gauge\na 1\n# HELP a help\n", "after samples"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("case %d", i+1), func(t *testing.T) {
+			err := newTextChecker(false).check(test.text)
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+
+	assert.Error(t, newTextChecker(true).check("# TYPE a gauge\na 1\n"))
+	assert.NoError(t, newTextChecker(true).check("# TYPE a counter\na_total 1\n# EOF\n"))
+}
+
+func TestPrometheusConformance(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("conformance")
+	defer store.DeleteScope(scope)
+
+	scope.Describe("rq_total", "total \"requests\"\nwith \\ backslash", stats.UnitNone)
+	// the values need escaping
+	scope.CounterWithTags("rq_total", &stats.Tag{Name: "path", Value: "/a\"b\\c\nd"}).Inc()
+	scope.CounterWithTags("rq_total", &stats.Tag{Name: "path", Value: "/"}).Add(2)
+	// the names are duplicated after sanitizing
+	scope.GaugeWithTags("cx_active",
+		&stats.Tag{Name: "peer.zone", Value: "a"},
+		&stats.Tag{Name: "peer_zone", Value: "b"},
+		&stats.Tag{Name: "1st", Value: "c"},
+	).Inc()
+	// the metric names collide after sanitizing
+	scope.Counter("x.rq.total").Add(3)
+	scope.Counter("x.rq_total").Add(4)
+	// the family is of conflicted types
+	scope.Gauge("dup").Set(1)
+	scope.Counter("dup").Inc()
+	// the reserved labels
+	h := scope.HistogramWithOptions("rq_time", stats.HistogramOptions{Unit: stats.UnitMilliseconds},
+		&stats.Tag{Name: "le", Value: "x"})
+	h.Record(10)
+	s := scope.HistogramWithTags("rq_size", &stats.Tag{Name: "quantile", Value: "x"})
+	s.Record(100)
+	store.Flush()
+
+	// the family is interleaved between types of gauge
+	g := stats.NewGauge("conformance.load", "conformance.load", []*stats.Tag{{Name: "kind", Value: "u"}})
+	g.Set(1)
+	ig := stats.NewIntGauge("conformance.load", "conformance.load", []*stats.Tag{{Name: "kind", Value: "i"}})
+	ig.Set(-1)
+	fg := stats.NewFloatGauge("conformance.load", "conformance.load", []*stats.Tag{{Name: "kind", Value: "f"}})
+	fg.Set(0.5)
+	filtered := newFilteredSnapshot(store, &metricsFilter{scope: "conformance."})
+	snap := &describedSnapshot{
+		snapshot: snapshot{
+			gauges:      append(filtered.Gauges(), g),
+			floatGauges: []*stats.FloatGauge{fg},
+			intGauges:   []*stats.IntGauge{ig},
+			counters:    filtered.Counters(),
+			histograms:  filtered.Histograms(),
+		},
+		Describer: store,
+	}
+
+	for _, exp := range []exposition{expositionText, expositionOpenMetrics} {
+		ff := newPrometheusFormatterFactoryWithOption("", NewPrometheusOption().WithSummaryNames("conformance.rq_size"))
+		f := ff.Create(&formatOptions{exposition: exp})
+		res := string(f.Format(snap))
+		assert.NoError(t, newTextChecker(exp == expositionOpenMetrics).check(res), res)
+
+		assert.Contains(t, res, `{path="/a\"b\\c\nd"}`)
+		assert.Contains(t, res, `_conformance_cx_active{_1st="c",peer_zone="b"} 1`)
+		assert.Contains(t, res, `_conformance_dup`)
+		assert.Contains(t, res, `_conformance_x_rq_total{} 3`)
+		assert.NotContains(t, res, `_conformance_x_rq_total{} 4`)
+		assert.Contains(t, res, `_conformance_load{kind="i"} -1`)
+		assert.NotContains(t, res, `le="x"`)
+		assert.NotContains(t, res, `quantile="x"`)
+	}
+
+	// the output is stable
+	f := newPrometheusFormatterFactory("myapp").Create(nil)
+	first := f.Format(store)
+	f = newPrometheusFormatterFactory("myapp").Create(nil)
+	assert.Equal(t, string(first), string(f.Format(store)))
+}
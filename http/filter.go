@@ -0,0 +1,154 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/kirk91/stats"
+)
+
+// the metric types could be filtered by.
+const (
+	metricTypeCounter   = "counter"
+	metricTypeGauge     = "gauge"
+	metricTypeHistogram = "histogram"
+)
+
+// metricsFilter selects the metrics to be presented, the zero value selects
+// all of them.
+type metricsFilter struct {
+	re     *regexp.Regexp // matches the key of metric
+	prefix string         // the prefix of metric name
+	scope  string         // the name of scope with trailing dot
+	typ    string         // the type of metric
+}
+
+// parseMetricsFilter parses the filter from the query of request, it returns
+// nil if none is specified.
+//
+// The filter query takes a regular expression which matches any part of the
+// metric key, e.g. upstream.rq_total{cluster=foo}, like the one of Envoy
+// admin. The prefix query selects the metrics whose names start with it, and
+// the scope query selects the metrics of the named scope and its children.
+// The type query is one of counter, gauge and histogram, the float and signed
+// gauges are presented as gauge. The metrics which have never been updated
+// are never presented, like the ones flushed to sinks.
+func parseMetricsFilter(query url.Values) (*metricsFilter, error) {
+	f := new(metricsFilter)
+	if v := query.Get("filter"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %v", err)
+		}
+		f.re = re
+	}
+	f.prefix = query.Get("prefix")
+	if v := strings.TrimSuffix(query.Get("scope"), "."); v != "" {
+		f.scope = v + "."
+	}
+	switch v := query.Get("type"); v {
+	case "", metricTypeCounter, metricTypeGauge, metricTypeHistogram:
+		f.typ = v
+	default:
+		return nil, fmt.Errorf("invalid type: %s", v)
+	}
+
+	if *f == (metricsFilter{}) {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func (f *metricsFilter) match(typ string, m stats.Metric, key string) bool {
+	if f.typ != "" && f.typ != typ {
+		return false
+	}
+	name := m.Name()
+	if !strings.HasPrefix(name, f.prefix) || !strings.HasPrefix(name, f.scope) {
+		return false
+	}
+	return f.re == nil || f.re.MatchString(key)
+}
+
+// filteredSnapshot is a MetricsSnapshot presenting the metrics selected by
+// the filter only.
+type filteredSnapshot struct {
+	stats.MetricsSnapshot
+	filter *metricsFilter
+}
+
+var (
+	_ stats.MetricsSnapshot = new(filteredSnapshot)
+	_ stats.Describer       = new(filteredSnapshot)
+)
+
+func newFilteredSnapshot(snapshot stats.MetricsSnapshot, filter *metricsFilter) stats.MetricsSnapshot {
+	if filter == nil {
+		return snapshot
+	}
+	return &filteredSnapshot{
+		MetricsSnapshot: snapshot,
+		filter:          filter,
+	}
+}
+
+func (s *filteredSnapshot) Gauges() []*stats.Gauge {
+	var res []*stats.Gauge
+	for _, g := range s.MetricsSnapshot.Gauges() {
+		if s.filter.match(metricTypeGauge, g, g.Key()) {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+func (s *filteredSnapshot) FloatGauges() []*stats.FloatGauge {
+	var res []*stats.FloatGauge
+	for _, g := range s.MetricsSnapshot.FloatGauges() {
+		if s.filter.match(metricTypeGauge, g, g.Key()) {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+func (s *filteredSnapshot) IntGauges() []*stats.IntGauge {
+	var res []*stats.IntGauge
+	for _, g := range s.MetricsSnapshot.IntGauges() {
+		if s.filter.match(metricTypeGauge, g, g.Key()) {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+func (s *filteredSnapshot) Counters() []*stats.Counter {
+	var res []*stats.Counter
+	for _, c := range s.MetricsSnapshot.Counters() {
+		if s.filter.match(metricTypeCounter, c, c.Key()) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (s *filteredSnapshot) Histograms() []*stats.Histogram {
+	var res []*stats.Histogram
+	for _, h := range s.MetricsSnapshot.Histograms() {
+		if s.filter.match(metricTypeHistogram, h, h.Key()) {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// Description returns the description of metrics if the underlying snapshot
+// knows it.
+func (s *filteredSnapshot) Description(name string) (stats.Description, bool) {
+	if d, ok := s.MetricsSnapshot.(stats.Describer); ok {
+		return d.Description(name)
+	}
+	return stats.Description{}, false
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/kirk91/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetricsFilter(t *testing.T) {
+	tests := []struct {
+		query  string
+		expect *metricsFilter
+		err    bool
+	}{
+		{"", nil, false},
+		{"window=1m", nil, false},
+		{"prefix=upstream", &metricsFilter{prefix: "upstream"}, false},
+		{"scope=upstream", &metricsFilter{scope: "upstream."}, false},
+		{"scope=upstream.", &metricsFilter{scope: "upstream."}, false},
+		{"type=gauge", &metricsFilter{typ: "gauge"}, false},
+		{"type=timer", nil, true},
+		{"usedonly", nil, false},
+		{"filter=(", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			assert.NoError(t, err)
+			f, err := parseMetricsFilter(query)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expect, f)
+		})
+	}
+
+	query, _ := url.ParseQuery("filter=rq_.*foo")
+	f, err := parseMetricsFilter(query)
+	assert.NoError(t, err)
+	assert.Equal(t, "rq_.*foo", f.re.String())
+}
+
+func TestFilteredSnapshot(t *testing.T) {
+	store := stats.NewStore(nil)
+	upstream := store.CreateScope("upstream")
+	defer store.DeleteScope(upstream)
+	upstreams := store.CreateScope("upstreams")
+	defer store.DeleteScope(upstreams)
+
+	upstream.Describe("rq_total", "total requests", stats.UnitNone)
+	upstream.CounterWithTags("rq_total", &stats.Tag{Name: "cluster", Value: "foo"}).Inc()
+	upstream.CounterWithTags("rq_total", &stats.Tag{Name: "cluster", Value: "bar"}).Inc()
+	upstream.NewChild("cx").Gauge("active").Inc()
+	upstream.FloatGauge("ratio").Set(0.5)
+	upstream.IntGauge("delta").Dec()
+	upstream.Histogram("rq_time").Record(1)
+	upstreams.Counter("total").Inc()
+	// created but never updated
+	upstream.Counter("rq_retry")
+	upstream.Histogram("rq_size")
+
+	filter := func(query string) stats.MetricsSnapshot {
+		q, _ := url.ParseQuery(query)
+		f, err := parseMetricsFilter(q)
+		assert.NoError(t, err)
+		return newFilteredSnapshot(store, f)
+	}
+	keys := func(s stats.MetricsSnapshot) []string {
+		var res []string
+		for _, m := range s.Gauges() {
+			res = append(res, m.Key())
+		}
+		for _, m := range s.FloatGauges() {
+			res = append(res, m.Key())
+		}
+		for _, m := range s.IntGauges() {
+			res = append(res, m.Key())
+		}
+		for _, m := range s.Counters() {
+			res = append(res, m.Key())
+		}
+		for _, m := range s.Histograms() {
+			res = append(res, m.Key())
+		}
+		return res
+	}
+
+	assert.True(t, filter("") == stats.MetricsSnapshot(store))
+	assert.ElementsMatch(t, []string{
+		"upstream.rq_total{cluster=foo}",
+		"upstream.rq_total{cluster=bar}",
+	}, keys(filter("filter=rq_total")))
+	assert.ElementsMatch(t, []string{"upstream.rq_total{cluster=foo}"}, keys(filter("filter=cluster=foo")))
+	assert.ElementsMatch(t, []string{
+		"upstream.cx.active",
+		"upstream.ratio",
+		"upstream.delta",
+	}, keys(filter("scope=upstream&type=gauge")))
+	assert.ElementsMatch(t, []string{"upstream.cx.active"}, keys(filter("scope=upstream.cx")))
+	assert.ElementsMatch(t, []string{
+		"upstream.rq_total{cluster=foo}",
+		"upstream.rq_total{cluster=bar}",
+		"upstreams.total",
+	}, keys(filter("prefix=upstream&type=counter")))
+	// the unused metrics are excluded
+	assert.ElementsMatch(t, []string{"upstream.rq_time"}, keys(filter("type=histogram")))
+	assert.NotContains(t, keys(filter("scope=upstream")), "upstream.rq_retry")
+
+	// the descriptions are passed through
+	desc, ok := filter("type=counter").(stats.Describer).Description("upstream.rq_total")
+	assert.True(t, ok)
+	assert.Equal(t, "total requests", desc.Help)
+}
@@ -1,182 +1,903 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
-	"regexp"
+	"io"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kirk91/stats"
+	"github.com/kirk91/stats/internal/expfmt"
 )
 
 type formatterFactory interface {
-	Create() formatter
+	// Create creates a formatter with the options, nil means the defaults.
+	Create(o *formatOptions) formatter
 }
 
+// formatOptions are the options of a single formatting, which are
+// usually specified by the query of request.
+type formatOptions struct {
+	// window is the sliding window of histograms presented instead of
+	// the last interval, zero means the last interval.
+	window time.Duration
+	// verbose indicates whether to present the descriptions of metrics.
+	verbose bool
+	// format overrides the format of handler, empty means no override.
+	format string
+	// exposition is the exposition format of prometheus, which is
+	// negotiated by the Accept header.
+	exposition exposition
+}
+
+// exposition is the exposition format of prometheus.
+type exposition int
+
+const (
+	expositionText        exposition = iota // the classic text format 0.0.4
+	expositionOpenMetrics                   // OpenMetrics 1.0.0
+	expositionProtobuf                      // the delimited protobuf of metric families
+)
+
+// the formats could be specified by the query of request.
+const formatJSON = "json"
+
 type formatter interface {
-	Format([]*stats.Gauge, []*stats.Counter, []*stats.Histogram) []byte
+	Format(stats.MetricsSnapshot) []byte
+	// ContentType returns the media type of the formatted content.
+	ContentType() string
+}
+
+// fallibleFormatter is a formatter which may fail to format, e.g. on
+// marshaling, the error is reported instead of an empty content.
+type fallibleFormatter interface {
+	formatter
+	TryFormat(stats.MetricsSnapshot) ([]byte, error)
+}
+
+// streamFormatter is a formatter which writes the formatted content to the
+// writer directly, without buffering the whole content.
+type streamFormatter interface {
+	formatter
+	FormatTo(w io.Writer, snapshot stats.MetricsSnapshot) error
 }
 
+const (
+	contentTypeText        = "text/plain"
+	contentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	contentTypeProtobuf    = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+	contentTypeJSON        = "application/json"
+)
+
 type plainFormatterFactory struct{}
 
 func newPlainFormatterFactory() formatterFactory {
 	return new(plainFormatterFactory)
 }
 
-func (*plainFormatterFactory) Create() formatter {
-	return newPlainFormatter()
+func (*plainFormatterFactory) Create(o *formatOptions) formatter {
+	return newPlainFormatter(o)
 }
 
-type plainFormatter struct{}
+type plainFormatter struct {
+	window  time.Duration
+	verbose bool
+}
 
-func newPlainFormatter() *plainFormatter {
-	return new(plainFormatter)
+func newPlainFormatter(o *formatOptions) *plainFormatter {
+	f := new(plainFormatter)
+	if o != nil {
+		f.window = o.window
+		f.verbose = o.verbose
+	}
+	return f
 }
 
-func (f *plainFormatter) Format(gauges []*stats.Gauge, counters []*stats.Counter, histograms []*stats.Histogram) []byte {
+func (f *plainFormatter) Format(snapshot stats.MetricsSnapshot) []byte {
+	describer, _ := snapshot.(stats.Describer)
 	metricNames := make([]string, 0)
 	metrics := make(map[string]interface{})
-	recordMetric := func(name string, value interface{}) {
+	comments := make(map[string]string)
+	recordMetric := func(name, extractedName string, value interface{}) {
 		metricNames = append(metricNames, name)
 		metrics[name] = value
+		if f.verbose && describer != nil {
+			if desc, ok := describer.Description(extractedName); ok {
+				comments[name] = formatDescription(desc)
+			}
+		}
 	}
 
-	for _, gauge := range gauges {
-		recordMetric(gauge.Name(), gauge.Value())
+	for _, gauge := range snapshot.Gauges() {
+		recordMetric(gauge.Key(), gauge.TagExtractedName(), gauge.Value())
+	}
+	for _, gauge := range snapshot.FloatGauges() {
+		recordMetric(gauge.Key(), gauge.TagExtractedName(), gauge.Value())
 	}
-	for _, counter := range counters {
-		recordMetric(counter.Name(), counter.Value())
+	for _, gauge := range snapshot.IntGauges() {
+		recordMetric(gauge.Key(), gauge.TagExtractedName(), gauge.Value())
 	}
-	for _, histogram := range histograms {
-		recordMetric(histogram.Name(), histogram.Summary())
+	for _, counter := range snapshot.Counters() {
+		recordMetric(counter.Key(), counter.TagExtractedName(), counter.Value())
+	}
+	for _, histogram := range snapshot.Histograms() {
+		if f.window > 0 {
+			recordMetric(histogram.Key(), histogram.TagExtractedName(), histogram.WindowSummary(f.window))
+			continue
+		}
+		recordMetric(histogram.Key(), histogram.TagExtractedName(), histogram.Summary())
 	}
 
 	sort.Strings(metricNames) // alphabet order
 	var buf bytes.Buffer
 	for _, name := range metricNames {
+		if comment, ok := comments[name]; ok {
+			buf.WriteString(fmt.Sprintf("%s: %v  # %s\n", name, metrics[name], comment))
+			continue
+		}
 		buf.WriteString(fmt.Sprintf("%s: %v\n", name, metrics[name]))
 	}
 	return buf.Bytes()
 }
 
+func (*plainFormatter) ContentType() string {
+	return contentTypeText
+}
+
+// formatDescription formats the description as a single line, e.g.
+// "request latency [milliseconds]".
+func formatDescription(desc stats.Description) string {
+	s := strings.Replace(desc.Help, "\n", " ", -1)
+	if desc.Unit != stats.UnitNone {
+		s = strings.TrimSpace(fmt.Sprintf("%s [%s]", s, desc.Unit))
+	}
+	return s
+}
+
 type prometheusFormatterFactory struct {
-	namespace string
+	namespace    string
+	summary      bool
+	summaryNames map[string]struct{}
+	window       time.Duration
 }
 
 func newPrometheusFormatterFactory(namespace string) formatterFactory {
-	return &prometheusFormatterFactory{
-		namespace: namespace,
+	return newPrometheusFormatterFactoryWithOption(namespace, nil)
+}
+
+func newPrometheusFormatterFactoryWithOption(namespace string, o *PrometheusOption) formatterFactory {
+	if o == nil {
+		o = NewPrometheusOption()
+	}
+	f := &prometheusFormatterFactory{
+		namespace:    namespace,
+		summary:      o.Summary,
+		summaryNames: make(map[string]struct{}, len(o.SummaryNames)),
+		window:       o.SummaryWindow,
 	}
+	for _, name := range o.SummaryNames {
+		f.summaryNames[name] = struct{}{}
+	}
+	return f
 }
 
-func (f *prometheusFormatterFactory) Create() formatter {
-	return newPrometheusFormatter(f.namespace)
+func (f *prometheusFormatterFactory) Create(o *formatOptions) formatter {
+	pf := newPrometheusFormatter(f.namespace)
+	pf.summary = f.summary
+	pf.summaryNames = f.summaryNames
+	pf.window = f.window
+	if o != nil {
+		// the window of request takes precedence
+		if o.window > 0 {
+			pf.window = o.window
+		}
+		switch o.exposition {
+		case expositionOpenMetrics:
+			pf.openMetrics = true
+		case expositionProtobuf:
+			return newProtobufFormatter(pf)
+		}
+	}
+	return pf
+}
+
+// PrometheusFormatter formats the metrics in the classic text format of
+// prometheus like PrometheusHandler, which is used to push the metrics, e.g.
+// to the pushgateway.
+type PrometheusFormatter struct {
+	ff formatterFactory
+}
+
+// NewPrometheusFormatter creates a PrometheusFormatter with the namespace
+// and option, nil means the default option.
+func NewPrometheusFormatter(namespace string, o *PrometheusOption) *PrometheusFormatter {
+	return &PrometheusFormatter{ff: newPrometheusFormatterFactoryWithOption(namespace, o)}
+}
+
+// FormatTo writes the formatted metrics of snapshot to w.
+func (f *PrometheusFormatter) FormatTo(w io.Writer, snapshot stats.MetricsSnapshot) error {
+	return f.ff.Create(nil).(streamFormatter).FormatTo(w, snapshot)
+}
+
+// ContentType returns the media type of the formatted metrics.
+func (f *PrometheusFormatter) ContentType() string {
+	return contentTypePrometheus
 }
 
 type prometheusFormatter struct {
 	namespace   string
-	metricTypes map[string]struct{}
+	prefix      string // the sanitized namespace with trailing underscore
+	openMetrics bool   // whether to format in OpenMetrics instead of the classic text
+	describer   stats.Describer
+
+	summary      bool
+	summaryNames map[string]struct{}
+	window       time.Duration
+
+	// the scratches to format numbers and histograms
+	num       []byte
+	counts    []uint64
+	quantiles []float64
 }
 
 func newPrometheusFormatter(namespace string) *prometheusFormatter {
-	return &prometheusFormatter{
-		namespace:   namespace,
-		metricTypes: make(map[string]struct{}),
-	}
+	f := &prometheusFormatter{namespace: namespace, num: make([]byte, 0, 64)}
+	f.prefix = expfmt.SanitizeLabelName(namespace + "_")
+	return f
+}
+
+// the types of prometheus metric, the order of which is the one presented.
+type promType int
+
+const (
+	promGauge promType = iota
+	promCounter
+	promSummary
+	promHistogram
+)
+
+var promTypeNames = [...]string{
+	promGauge:     "gauge",
+	promCounter:   "counter",
+	promSummary:   "summary",
+	promHistogram: "histogram",
+}
+
+// promEntry is a series to be presented, the family name of which is the
+// concatenation of the namespace, name and unit suffix.
+type promEntry struct {
+	name   string // the sanitized name without namespace
+	unit   string // the unit suffix, e.g. _seconds
+	typ    promType
+	labels string // the formatted labels
+	index  int    // the order of appearance
+	metric interface{}
+}
+
+func (e *promEntry) metricName() string {
+	return e.metric.(stats.Metric).TagExtractedName()
+}
+
+var promEntriesPool = sync.Pool{
+	New: func() interface{} {
+		return new([]promEntry)
+	},
 }
 
-// Format formats the metrics to a text-based format which prometheus accpets.
-// Refer to https://prometheus.io/docs/instrumenting/exposition_formats/
-func (f *prometheusFormatter) Format(gauges []*stats.Gauge, counters []*stats.Counter, histograms []*stats.Histogram) []byte {
+var bufioWriterPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, 32*1024)
+	},
+}
+
+// Format formats the metrics to a text-based format which prometheus accpets,
+// either the classic one or OpenMetrics.
+func (f *prometheusFormatter) Format(snapshot stats.MetricsSnapshot) []byte {
 	buf := new(bytes.Buffer)
+	f.FormatTo(buf, snapshot) //nolint:errcheck
+	return buf.Bytes()
+}
+
+// FormatTo writes the metrics to w in a text-based format which prometheus
+// accpets, either the classic one or OpenMetrics. The metrics are grouped by
+// family, and the families and the series of them are sorted by name and
+// labels. The series of a family conflicted with the type of the first one
+// are dropped, as the conflicted family is rejected by prometheus, and so are
+// the duplicated series of the colliding names.
+// Refer to https://prometheus.io/docs/instrumenting/exposition_formats/ and
+// https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+func (f *prometheusFormatter) FormatTo(w io.Writer, snapshot stats.MetricsSnapshot) error {
+	f.describer, _ = snapshot.(stats.Describer)
+
+	entriesPtr := promEntriesPool.Get().(*[]promEntry)
+	entries := f.collect((*entriesPtr)[:0], snapshot)
+	defer func() {
+		// release the metrics
+		for i := range entries {
+			entries[i] = promEntry{}
+		}
+		*entriesPtr = entries[:0]
+		promEntriesPool.Put(entriesPtr)
+	}()
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if c := compareConcat(a.name, a.unit, b.name, b.unit); c != 0 {
+			return c < 0
+		}
+		if a.labels != b.labels {
+			return a.labels < b.labels
+		}
+		// the snapshot isn't ordered, so the series of colliding names
+		// are ordered by the original names to keep the first stable.
+		if an, bn := a.metricName(), b.metricName(); an != bn {
+			return an < bn
+		}
+		return a.index < b.index
+	})
+
+	bw := bufioWriterPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	defer func() {
+		bw.Reset(nil)
+		bufioWriterPool.Put(bw)
+	}()
+
+	for i := 0; i < len(entries); {
+		// the family is entries[i:j]
+		j, first := i+1, i
+		for ; j < len(entries); j++ {
+			if compareConcat(entries[i].name, entries[i].unit, entries[j].name, entries[j].unit) != 0 {
+				break
+			}
+			if entries[j].index < entries[first].index {
+				first = j
+			}
+		}
+		family := &entries[first]
+		f.writeFamilyHeader(bw, family)
+		var last *promEntry
+		for k := i; k < j; k++ {
+			e := &entries[k]
+			if e.typ != family.typ {
+				continue
+			}
+			// the series of metrics whose names collide after sanitizing,
+			// e.g. x.rq.total and x.rq_total, are duplicated, only the one
+			// of the least original name is kept.
+			if last != nil && last.labels == e.labels {
+				continue
+			}
+			f.writeEntry(bw, e)
+			last = e
+		}
+		i = j
+	}
+	if f.openMetrics {
+		bw.WriteString("# EOF\n") //nolint:errcheck
+	}
+	return bw.Flush()
+}
 
-	for _, gauge := range gauges {
-		f.formatGauge(buf, gauge)
+// collect appends the entries of metrics in the snapshot to entries.
+func (f *prometheusFormatter) collect(entries []promEntry, snapshot stats.MetricsSnapshot) []promEntry {
+	add := func(name, unit string, typ promType, labels string, m interface{}) {
+		entries = append(entries, promEntry{
+			name:   name,
+			unit:   unit,
+			typ:    typ,
+			labels: labels,
+			index:  len(entries),
+			metric: m,
+		})
+	}
+	for _, g := range snapshot.Gauges() {
+		add(g.SanitizedName(), "", promGauge, g.FormattedLabels(), g)
+	}
+	for _, g := range snapshot.FloatGauges() {
+		add(g.SanitizedName(), "", promGauge, g.FormattedLabels(), g)
+	}
+	for _, g := range snapshot.IntGauges() {
+		add(g.SanitizedName(), "", promGauge, g.FormattedLabels(), g)
 	}
-	for _, counter := range counters {
-		f.formatCounter(buf, counter)
+	for _, c := range snapshot.Counters() {
+		name := c.SanitizedName()
+		if f.openMetrics {
+			// the samples of counter are suffixed with _total in
+			// OpenMetrics, while the family isn't.
+			name = strings.TrimSuffix(name, "_total")
+		}
+		add(name, "", promCounter, c.FormattedLabels(), c)
 	}
-	for _, histogram := range histograms {
-		f.formatHistogram(buf, histogram)
+	for _, h := range snapshot.Histograms() {
+		name, unit := h.SanitizedName(), unitSuffix(h.Unit().Base())
+		if strings.HasSuffix(name, unit) {
+			unit = ""
+		}
+		if f.isSummary(h) {
+			add(name, unit, promSummary, metricLabels(h, "quantile"), h)
+		} else {
+			add(name, unit, promHistogram, metricLabels(h, "le"), h)
+		}
 	}
+	return entries
+}
 
-	return buf.Bytes()
+// exposedMetric is a metric caching its sanitized name and labels.
+type exposedMetric interface {
+	stats.Metric
+	SanitizedName() string
+	FormattedLabels() string
 }
 
-func (f *prometheusFormatter) formatCounter(buf *bytes.Buffer, c *stats.Counter) {
-	name := f.formatMeticName(c.TagExtractedName())
-	value := c.Value()
-	tags := f.formatTags(c.Tags())
-	if f.recordMetricType(name) {
-		buf.WriteString(fmt.Sprintf("# TYPE %s counter\n", name))
+// metricLabels returns the formatted labels of metric without the reserved
+// one, e.g. le of histogram. The cached ones are returned unless the reserved
+// one is present.
+func metricLabels(m exposedMetric, reserved string) string {
+	if reserved == "" {
+		return m.FormattedLabels()
+	}
+	for _, tag := range m.Tags() {
+		// the reserved names are valid, so no need to sanitize
+		if tag.Name == reserved {
+			return expfmt.FormatLabels(appendLabels(nil, m.Tags(), reserved))
+		}
 	}
-	buf.WriteString(fmt.Sprintf("%s{%s} %d\n", name, tags, value))
+	return m.FormattedLabels()
 }
 
-func (f *prometheusFormatter) formatGauge(buf *bytes.Buffer, g *stats.Gauge) {
-	name := f.formatMeticName(g.TagExtractedName())
-	value := g.Value()
-	tags := f.formatTags(g.Tags())
-	if f.recordMetricType(name) {
-		buf.WriteString(fmt.Sprintf("# TYPE %s gauge\n", name))
+// appendLabels appends the tags to labels as the labels of the exposition
+// formats without the reserved one.
+func appendLabels(labels []expfmt.Label, tags []*stats.Tag, reserved string) []expfmt.Label {
+	for _, tag := range tags {
+		labels = expfmt.AppendLabel(labels, tag.Name, tag.Value, reserved)
 	}
-	buf.WriteString(fmt.Sprintf("%s{%s} %d\n", name, tags, value))
+	return labels
 }
 
-func (f *prometheusFormatter) formatHistogram(buf *bytes.Buffer, h *stats.Histogram) {
-	name := f.formatMeticName(h.TagExtractedName())
-	tags := f.formatTags(h.Tags())
-	if f.recordMetricType(name) {
-		buf.WriteString(fmt.Sprintf("# TYPE %s histogram\n", name))
+// unitSuffix returns the suffix of unit in metric names, e.g. _seconds.
+func unitSuffix(unit stats.Unit) string {
+	switch unit {
+	case stats.UnitNone:
+		return ""
+	case stats.UnitSeconds:
+		return "_seconds"
+	case stats.UnitBytes:
+		return "_bytes"
 	}
-	hStats := h.CumulativeStatistics()
-	f.formatHistogramValue(buf, name, tags, hStats)
+	return "_" + unit.String()
 }
 
-func (f *prometheusFormatter) formatHistogramValue(buf *bytes.Buffer, name, tags string, hStats *stats.HistogramStatistics) {
-	sbs := hStats.SupportedBuckets()
-	cbs := hStats.ComputedBuckets()
+// compareConcat compares a1+a2 and b1+b2 without concatenating them.
+func compareConcat(a1, a2, b1, b2 string) int {
+	for {
+		if a1 == "" {
+			a1, a2 = a2, ""
+		}
+		if b1 == "" {
+			b1, b2 = b2, ""
+		}
+		if a1 == "" || b1 == "" {
+			break
+		}
+		n := len(a1)
+		if len(b1) < n {
+			n = len(b1)
+		}
+		if c := strings.Compare(a1[:n], b1[:n]); c != 0 {
+			return c
+		}
+		a1, b1 = a1[n:], b1[n:]
+	}
+	switch {
+	case a1 == "" && b1 == "":
+		return 0
+	case a1 == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (f *prometheusFormatter) ContentType() string {
+	if f.openMetrics {
+		return contentTypeOpenMetrics
+	}
+	return contentTypePrometheus
+}
+
+func (f *prometheusFormatter) writeName(bw *bufio.Writer, e *promEntry, suffix string) {
+	bw.WriteString(f.prefix) //nolint:errcheck
+	bw.WriteString(e.name)   //nolint:errcheck
+	bw.WriteString(e.unit)   //nolint:errcheck
+	bw.WriteString(suffix)   //nolint:errcheck
+}
+
+// writeFamilyHeader writes the HELP, UNIT and TYPE lines of the family.
+func (f *prometheusFormatter) writeFamilyHeader(bw *bufio.Writer, e *promEntry) {
+	desc, described := f.description(e.metricName())
+	if described && desc.Help != "" {
+		bw.WriteString("# HELP ") //nolint:errcheck
+		f.writeName(bw, e, "")
+		bw.WriteByte(' ') //nolint:errcheck
+		f.writeHelp(bw, desc.Help)
+		bw.WriteByte('\n') //nolint:errcheck
+	}
+
+	// the unit is the one of the exposed values, or the one from the
+	// description.
+	unit := stats.UnitNone
+	if h, ok := e.metric.(*stats.Histogram); ok {
+		unit = h.Unit().Base()
+	}
+	if unit == stats.UnitNone {
+		unit = desc.Unit
+	}
+	writeUnit := false
+	switch {
+	case unit == stats.UnitNone:
+	case f.openMetrics:
+		// the unit must be the suffix of family name in OpenMetrics.
+		writeUnit = e.unit != "" || strings.HasSuffix(e.name, unitSuffix(unit))
+	case described:
+		writeUnit = true
+	}
+	if writeUnit {
+		bw.WriteString("# UNIT ") //nolint:errcheck
+		f.writeName(bw, e, "")
+		bw.WriteByte(' ')             //nolint:errcheck
+		bw.WriteString(unit.String()) //nolint:errcheck
+		bw.WriteByte('\n')            //nolint:errcheck
+	}
+
+	bw.WriteString("# TYPE ") //nolint:errcheck
+	f.writeName(bw, e, "")
+	bw.WriteByte(' ')                    //nolint:errcheck
+	bw.WriteString(promTypeNames[e.typ]) //nolint:errcheck
+	bw.WriteByte('\n')                   //nolint:errcheck
+}
+
+// writeHelp writes the help text with the backslash and line feed escaped,
+// and the double quote as well in OpenMetrics.
+func (f *prometheusFormatter) writeHelp(bw *bufio.Writer, s string) {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		var escaped string
+		switch s[i] {
+		case '\\':
+			escaped = `\\`
+		case '\n':
+			escaped = `\n`
+		case '"':
+			if !f.openMetrics {
+				continue
+			}
+			escaped = `\"`
+		default:
+			continue
+		}
+		bw.WriteString(s[start:i]) //nolint:errcheck
+		bw.WriteString(escaped)    //nolint:errcheck
+		start = i + 1
+	}
+	bw.WriteString(s[start:]) //nolint:errcheck
+}
+
+// writeSample writes a sample line, the extra label is appended to the
+// labels of entry if the name of it isn't empty.
+func (f *prometheusFormatter) writeSample(bw *bufio.Writer, e *promEntry, suffix,
+	extraName string, extraValue, value []byte) {
+	f.writeName(bw, e, suffix)
+	bw.WriteByte('{')        //nolint:errcheck
+	bw.WriteString(e.labels) //nolint:errcheck
+	if extraName != "" {
+		if e.labels != "" {
+			bw.WriteByte(',') //nolint:errcheck
+		}
+		bw.WriteString(extraName) //nolint:errcheck
+		bw.WriteString(`="`)      //nolint:errcheck
+		bw.Write(extraValue)      //nolint:errcheck
+		bw.WriteByte('"')         //nolint:errcheck
+	}
+	bw.WriteString("} ") //nolint:errcheck
+	bw.Write(value)      //nolint:errcheck
+	bw.WriteByte('\n')   //nolint:errcheck
+}
+
+func (f *prometheusFormatter) writeEntry(bw *bufio.Writer, e *promEntry) {
+	switch m := e.metric.(type) {
+	case *stats.Gauge:
+		f.writeSample(bw, e, "", "", nil, strconv.AppendUint(f.num[:0], m.Value(), 10))
+	case *stats.FloatGauge:
+		f.writeSample(bw, e, "", "", nil, strconv.AppendFloat(f.num[:0], m.Value(), 'g', -1, 64))
+	case *stats.IntGauge:
+		f.writeSample(bw, e, "", "", nil, strconv.AppendInt(f.num[:0], m.Value(), 10))
+	case *stats.Counter:
+		if !f.openMetrics {
+			f.writeSample(bw, e, "", "", nil, strconv.AppendUint(f.num[:0], m.Value(), 10))
+			return
+		}
+		f.writeSample(bw, e, "_total", "", nil, strconv.AppendUint(f.num[:0], m.Value(), 10))
+		f.writeCreated(bw, e, m.CreatedAt())
+	case *stats.Histogram:
+		if e.typ == promSummary {
+			f.writeSummary(bw, e, m)
+		} else {
+			f.writeHistogram(bw, e, m)
+		}
+		if f.openMetrics {
+			f.writeCreated(bw, e, m.CreatedAt())
+		}
+	}
+}
+
+// writeCreated writes the created timestamp of OpenMetrics in seconds.
+func (f *prometheusFormatter) writeCreated(bw *bufio.Writer, e *promEntry, created time.Time) {
+	ts := strconv.AppendFloat(f.num[:0], float64(created.UnixNano())/1e9, 'f', 3, 64)
+	f.writeSample(bw, e, "_created", "", nil, ts)
+}
+
+// writeSummary writes the histogram as summary, the quantiles are computed
+// over the last interval or the window, while the sum and count are
+// cumulative.
+func (f *prometheusFormatter) writeSummary(bw *bufio.Writer, e *promEntry, h *stats.Histogram) {
+	unit := h.Unit()
+	sqs := h.SupportedQuantiles()
+	var cqs []float64
+	if f.window > 0 {
+		// the window statistics are merged from the slots anyway
+		cqs = h.WindowStatistics(f.window).ComputedQuantiles()
+	} else {
+		f.quantiles = h.AppendIntervalQuantiles(f.quantiles[:0])
+		cqs = f.quantiles
+	}
+	for i := 0; i < len(sqs); i++ {
+		q := strconv.AppendFloat(f.num[:0], sqs[i], 'g', -1, 64)
+		v := strconv.AppendFloat(q[len(q):], unit.ToBase(cqs[i]), 'g', 8, 64)
+		f.writeSample(bw, e, "", "quantile", q, v)
+	}
+	count, sum := h.CumulativeCountAndSum()
+	f.writeSample(bw, e, "_sum", "", nil, strconv.AppendFloat(f.num[:0], unit.ToBase(sum), 'g', 8, 64))
+	f.writeSample(bw, e, "_count", "", nil, strconv.AppendUint(f.num[:0], count, 10))
+}
+
+// writeHistogram writes the cumulative values of histogram, which are
+// converted to the base unit, e.g. seconds.
+func (f *prometheusFormatter) writeHistogram(bw *bufio.Writer, e *promEntry, h *stats.Histogram) {
+	unit := h.Unit()
+	sbs := h.SupportedBuckets()
+	cbs, sampleCount, sampleSum := h.AppendCumulativeBuckets(f.counts[:0])
+	f.counts = cbs
 	for i := 0; i < len(sbs); i++ {
-		b := sbs[i]
-		v := cbs[i]
-		bucketTags := fmt.Sprintf("%s,le=\"%.8g\"", tags, b)
-		// trim the comma prefix when tags is empty
-		bucketTags = strings.TrimPrefix(bucketTags, ",")
-		buf.WriteString(fmt.Sprintf("%s_bucket{%s} %d\n", name, bucketTags, v))
+		le := strconv.AppendFloat(f.num[:0], unit.ToBase(sbs[i]), 'g', 8, 64)
+		v := strconv.AppendUint(le[len(le):], cbs[i], 10)
+		f.writeSample(bw, e, "_bucket", "le", le, v)
+	}
+	count := strconv.AppendUint(f.num[:0], sampleCount, 10)
+	f.writeSample(bw, e, "_bucket", "le", infBound, count)
+	sum := strconv.AppendFloat(count[len(count):], unit.ToBase(sampleSum), 'g', 8, 64)
+	f.writeSample(bw, e, "_sum", "", nil, sum)
+	f.writeSample(bw, e, "_count", "", nil, count)
+}
+
+var infBound = []byte("+Inf")
+
+func (f *prometheusFormatter) isSummary(h *stats.Histogram) bool {
+	if f.summary {
+		return true
 	}
-	bucketTags := strings.TrimPrefix(fmt.Sprintf("%s,le=\"+Inf\"", tags), ",")
-	buf.WriteString(fmt.Sprintf("%s_bucket{%s} %d\n", name, bucketTags, hStats.SampleCount()))
-	buf.WriteString(fmt.Sprintf("%s_sum{%s} %.8g\n", name, tags, hStats.SampleSum()))
-	buf.WriteString(fmt.Sprintf("%s_count{%s} %d\n", name, tags, hStats.SampleCount()))
+	_, ok := f.summaryNames[h.TagExtractedName()]
+	return ok
 }
 
-func (f *prometheusFormatter) recordMetricType(metricName string) bool {
-	if _, ok := f.metricTypes[metricName]; ok {
-		return false
+func (f *prometheusFormatter) description(extractedName string) (stats.Description, bool) {
+	if f.describer == nil {
+		return stats.Description{}, false
 	}
-	f.metricTypes[metricName] = struct{}{}
-	return true
+	return f.describer.Description(extractedName)
+}
+
+type jsonFormatterFactory struct{}
+
+func newJSONFormatterFactory() formatterFactory {
+	return new(jsonFormatterFactory)
 }
 
-func (f *prometheusFormatter) formatMeticName(name string) string {
-	// A metric name should have a (single-word) application prefix relevant to
-	// the domain the metric belongs to.
-	// Refer to https://prometheus.io/docs/practices/naming/#metric-names
-	return f.sanitizeName(fmt.Sprintf("%s_%s", f.namespace, name))
+func (*jsonFormatterFactory) Create(o *formatOptions) formatter {
+	return newJSONFormatter(o)
 }
 
-func (f *prometheusFormatter) formatTags(tags []*stats.Tag) string {
-	res := make([]string, len(tags))
-	for i, tag := range tags {
-		res[i] = fmt.Sprintf("%s=\"%s\"", f.sanitizeName(tag.Name), tag.Value)
+// jsonFormatter formats the metrics to JSON like the stats admin of Envoy,
+// the histograms present the values of both the last interval (or the
+// window) and the cumulative ones.
+type jsonFormatter struct {
+	window time.Duration
+}
+
+func newJSONFormatter(o *formatOptions) *jsonFormatter {
+	f := new(jsonFormatter)
+	if o != nil {
+		f.window = o.window
 	}
-	return strings.Join(res, ",")
+	return f
 }
 
-func (f *prometheusFormatter) sanitizeName(name string) string {
-	// The name must match the regex [a-zA-Z_][a-zA-Z0-9_]* as required by
-	// prometheus. Refer to https://prometheus.io/docs/concepts/data_model/
-	re := regexp.MustCompile("[^a-zA-Z0-9_]")
-	return re.ReplaceAllString(name, "_")
+type jsonStats struct {
+	Stats      []*jsonStat      `json:"stats"`
+	Histograms []*jsonHistogram `json:"histograms"`
+}
+
+type jsonStat struct {
+	key string
+
+	Name     string            `json:"name"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Type     string            `json:"type"`
+	Value    interface{}       `json:"value"`
+	Interval *uint64           `json:"interval,omitempty"` // only for counters
+}
+
+type jsonHistogram struct {
+	key string
+
+	Name      string                   `json:"name"`
+	Tags      map[string]string        `json:"tags,omitempty"`
+	Unit      string                   `json:"unit,omitempty"`
+	Count     jsonHistogramValue       `json:"count"`
+	Sum       jsonHistogramValue       `json:"sum"`
+	Quantiles []*jsonHistogramQuantile `json:"quantiles"`
+	Buckets   []*jsonHistogramBucket   `json:"buckets"`
+}
+
+type jsonHistogramValue struct {
+	Interval   interface{} `json:"interval"`
+	Cumulative interface{} `json:"cumulative"`
+}
+
+type jsonHistogramQuantile struct {
+	Quantile float64 `json:"quantile"`
+	jsonHistogramValue
+}
+
+type jsonHistogramBucket struct {
+	UpperBound jsonFloat `json:"le"`
+	jsonHistogramValue
+}
+
+// jsonFloat is a float encoded as a string if it's not finite, i.e. "NaN",
+// "+Inf" and "-Inf", which JSON can't represent.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFormatter) Format(snapshot stats.MetricsSnapshot) []byte {
+	b, _ := f.TryFormat(snapshot)
+	return b
+}
+
+// TryFormat formats the metrics like Format, and reports the error of
+// marshaling.
+func (f *jsonFormatter) TryFormat(snapshot stats.MetricsSnapshot) ([]byte, error) {
+	res := &jsonStats{
+		Stats:      make([]*jsonStat, 0),
+		Histograms: make([]*jsonHistogram, 0),
+	}
+	recordStat := func(m stats.Metric, key, typ string, value interface{}) *jsonStat {
+		s := &jsonStat{
+			key:   key,
+			Name:  m.TagExtractedName(),
+			Tags:  formatJSONTags(m.Tags()),
+			Type:  typ,
+			Value: value,
+		}
+		res.Stats = append(res.Stats, s)
+		return s
+	}
+
+	for _, gauge := range snapshot.Gauges() {
+		recordStat(gauge, gauge.Key(), metricTypeGauge, gauge.Value())
+	}
+	for _, gauge := range snapshot.FloatGauges() {
+		recordStat(gauge, gauge.Key(), metricTypeGauge, jsonFloat(gauge.Value()))
+	}
+	for _, gauge := range snapshot.IntGauges() {
+		recordStat(gauge, gauge.Key(), metricTypeGauge, gauge.Value())
+	}
+	for _, counter := range snapshot.Counters() {
+		interval := counter.IntervalValue()
+		s := recordStat(counter, counter.Key(), metricTypeCounter, counter.Value())
+		s.Interval = &interval
+	}
+	for _, histogram := range snapshot.Histograms() {
+		res.Histograms = append(res.Histograms, f.formatHistogram(histogram))
+	}
+
+	// alphabet order
+	sort.Slice(res.Stats, func(i, j int) bool {
+		return res.Stats[i].key < res.Stats[j].key
+	})
+	sort.Slice(res.Histograms, func(i, j int) bool {
+		return res.Histograms[i].key < res.Histograms[j].key
+	})
+	return json.Marshal(res)
+}
+
+func (f *jsonFormatter) formatHistogram(h *stats.Histogram) *jsonHistogram {
+	var itlStats *stats.HistogramStatistics
+	if f.window > 0 {
+		itlStats = h.WindowStatistics(f.window)
+	} else {
+		itlStats = h.IntervalStatistics()
+	}
+	cumStats := h.CumulativeStatistics()
+
+	res := &jsonHistogram{
+		key:  h.Key(),
+		Name: h.TagExtractedName(),
+		Tags: formatJSONTags(h.Tags()),
+		Count: jsonHistogramValue{
+			Interval:   itlStats.SampleCount(),
+			Cumulative: cumStats.SampleCount(),
+		},
+		Sum: jsonHistogramValue{
+			Interval:   jsonFloat(itlStats.SampleSum()),
+			Cumulative: jsonFloat(cumStats.SampleSum()),
+		},
+	}
+	if unit := h.Unit(); unit != stats.UnitNone {
+		res.Unit = unit.String()
+	}
+
+	sqs := itlStats.SupportedQuantiles()
+	itlQs, cumQs := itlStats.ComputedQuantiles(), cumStats.ComputedQuantiles()
+	res.Quantiles = make([]*jsonHistogramQuantile, len(sqs))
+	for i, q := range sqs {
+		res.Quantiles[i] = &jsonHistogramQuantile{
+			Quantile:           q,
+			jsonHistogramValue: jsonHistogramValue{Interval: jsonFloat(itlQs[i]), Cumulative: jsonFloat(cumQs[i])},
+		}
+	}
+	sbs := itlStats.SupportedBuckets()
+	itlBs, cumBs := itlStats.ComputedBuckets(), cumStats.ComputedBuckets()
+	res.Buckets = make([]*jsonHistogramBucket, len(sbs))
+	for i, b := range sbs {
+		res.Buckets[i] = &jsonHistogramBucket{
+			UpperBound:         jsonFloat(b),
+			jsonHistogramValue: jsonHistogramValue{Interval: itlBs[i], Cumulative: cumBs[i]},
+		}
+	}
+	return res
+}
+
+func (*jsonFormatter) ContentType() string {
+	return contentTypeJSON
+}
+
+func formatJSONTags(tags []*stats.Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		res[tag.Name] = tag.Value
+	}
+	return res
 }
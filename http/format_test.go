@@ -3,12 +3,36 @@ package http
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kirk91/stats"
 	"github.com/stretchr/testify/assert"
 )
 
+type snapshot struct {
+	gauges      []*stats.Gauge
+	floatGauges []*stats.FloatGauge
+	intGauges   []*stats.IntGauge
+	counters    []*stats.Counter
+	histograms  []*stats.Histogram
+}
+
+func (s *snapshot) Gauges() []*stats.Gauge           { return s.gauges }
+func (s *snapshot) FloatGauges() []*stats.FloatGauge { return s.floatGauges }
+func (s *snapshot) IntGauges() []*stats.IntGauge     { return s.intGauges }
+func (s *snapshot) Counters() []*stats.Counter       { return s.counters }
+func (s *snapshot) Histograms() []*stats.Histogram   { return s.histograms }
+
+type describedSnapshot struct {
+	snapshot
+	stats.Describer
+}
+
 func TestPlainFormatter(t *testing.T) {
 	store := stats.NewStore(nil)
 	scope := store.CreateScope("plain-stats")
@@ -20,18 +44,40 @@ func TestPlainFormatter(t *testing.T) {
 	c1.Inc()
 	h1 := scope.Histogram("h1")
 	h1.Record(1)
+	fg1 := scope.FloatGauge("fg1")
+	fg1.Set(0.25)
+	ig1 := scope.IntGauge("ig1")
+	ig1.Dec()
 
 	ff := newPlainFormatterFactory()
-	f := ff.Create()
-	res := f.Format(scope.Gauges(), scope.Counters(), scope.Histograms())
+	f := ff.Create(nil)
+	res := f.Format(store)
 
 	var expect bytes.Buffer
 	expect.WriteString(fmt.Sprintf("%s: %v\n", c1.Name(), c1.Value()))
+	expect.WriteString(fmt.Sprintf("%s: %v\n", fg1.Name(), fg1.Value()))
 	expect.WriteString(fmt.Sprintf("%s: %v\n", g1.Name(), g1.Value()))
 	expect.WriteString(fmt.Sprintf("%s: %v\n", h1.Name(), h1.Summary()))
+	expect.WriteString(fmt.Sprintf("%s: %v\n", ig1.Name(), ig1.Value()))
 	assert.Equal(t, expect.Bytes(), res)
 }
 
+func TestPlainFormatterWithTags(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("upstream")
+	defer store.DeleteScope(scope)
+
+	scope.Tagged(map[string]string{"cluster": "foo"}).Counter("rq_total").Inc()
+	scope.Tagged(map[string]string{"cluster": "bar"}).Counter("rq_total").Add(2)
+
+	f := newPlainFormatterFactory().Create(nil)
+	res := f.Format(store)
+	expect := `upstream.rq_total{cluster=bar}: 2
+upstream.rq_total{cluster=foo}: 1
+`
+	assert.Equal(t, expect, string(res))
+}
+
 func TestFormatGaugeForPrometheus(t *testing.T) {
 	g1 := stats.NewGauge("foo.sash", "foo",
 		[]*stats.Tag{{Name: "tag1", Value: "sash"}})
@@ -42,13 +88,33 @@ func TestFormatGaugeForPrometheus(t *testing.T) {
 	g2.Set(2)
 
 	ff := newPrometheusFormatterFactory("myapp")
-	f := ff.Create()
-	res := f.Format([]*stats.Gauge{g1, g2, g3}, nil, nil)
-	expect := `# TYPE myapp_foo gauge
-myapp_foo{tag1="sash"} 1
-myapp_foo{tag1="bos"} 2
-# TYPE myapp_bar gauge
+	f := ff.Create(nil)
+	res := f.Format(&snapshot{gauges: []*stats.Gauge{g1, g2, g3}})
+	expect := `# TYPE myapp_bar gauge
 myapp_bar{} 0
+# TYPE myapp_foo gauge
+myapp_foo{tag1="bos"} 2
+myapp_foo{tag1="sash"} 1
+`
+	assert.Equal(t, expect, string(res))
+}
+
+func TestFormatFloatAndIntGaugeForPrometheus(t *testing.T) {
+	fg := stats.NewFloatGauge("ratio", "ratio", nil)
+	fg.Set(0.75)
+	ig := stats.NewIntGauge("delta", "delta", nil)
+	ig.Sub(3)
+
+	ff := newPrometheusFormatterFactory("myapp")
+	f := ff.Create(nil)
+	res := f.Format(&snapshot{
+		floatGauges: []*stats.FloatGauge{fg},
+		intGauges:   []*stats.IntGauge{ig},
+	})
+	expect := `# TYPE myapp_delta gauge
+myapp_delta{} -3
+# TYPE myapp_ratio gauge
+myapp_ratio{} 0.75
 `
 	assert.Equal(t, expect, string(res))
 }
@@ -63,13 +129,13 @@ func TestFormatCounterForPrometheus(t *testing.T) {
 	c2.Add(2)
 
 	ff := newPrometheusFormatterFactory("myapp")
-	f := ff.Create()
-	res := f.Format(nil, []*stats.Counter{c1, c2, c3}, nil)
-	expect := `# TYPE myapp_foo counter
-myapp_foo{tag1="sash"} 1
-myapp_foo{tag1="bos"} 2
-# TYPE myapp_bar counter
+	f := ff.Create(nil)
+	res := f.Format(&snapshot{counters: []*stats.Counter{c1, c2, c3}})
+	expect := `# TYPE myapp_bar counter
 myapp_bar{} 0
+# TYPE myapp_foo counter
+myapp_foo{tag1="bos"} 2
+myapp_foo{tag1="sash"} 1
 `
 	assert.Equal(t, expect, string(res))
 }
@@ -78,8 +144,8 @@ func TestFormatHistogramForPrometheus(t *testing.T) {
 	t.Run("no values and tags", func(t *testing.T) {
 		h := stats.NewHistogram(nil, "h", "h", nil)
 		ff := newPrometheusFormatterFactory("myapp")
-		f := ff.Create()
-		res := f.Format(nil, nil, []*stats.Histogram{h})
+		f := ff.Create(nil)
+		res := f.Format(&snapshot{histograms: []*stats.Histogram{h}})
 		expect := `# TYPE myapp_h histogram
 myapp_h_bucket{le="0.5"} 0
 myapp_h_bucket{le="1"} 0
@@ -117,8 +183,8 @@ myapp_h_count{} 0
 		h2.RefreshIntervalStatistics()
 
 		ff := newPrometheusFormatterFactory("myapp")
-		f := ff.Create()
-		res := f.Format(nil, nil, []*stats.Histogram{h1, h2})
+		f := ff.Create(nil)
+		res := f.Format(&snapshot{histograms: []*stats.Histogram{h1, h2}})
 		expect := `# TYPE myapp_h histogram
 myapp_h_bucket{tag1="foo",le="0.5"} 0
 myapp_h_bucket{tag1="foo",le="1"} 0
@@ -168,3 +234,344 @@ myapp_h_count{tag2="bar"} 1
 		assert.Equal(t, expect, string(res))
 	})
 }
+
+func TestFormatHistogramWithUnitForPrometheus(t *testing.T) {
+	store := stats.NewStore(nil)
+	timer := store.CreateScope("").Timer("rq_time")
+	timer.RecordDuration(time.Millisecond * 20)
+	timer.Histogram().RefreshIntervalStatistics()
+	h := store.CreateScope("").HistogramWithOptions("rq_size_bytes", stats.HistogramOptions{
+		Buckets: []float64{1024},
+		Unit:    stats.UnitBytes,
+	})
+
+	ff := newPrometheusFormatterFactory("myapp")
+	f := ff.Create(nil)
+	res := string(f.Format(&snapshot{histograms: []*stats.Histogram{timer.Histogram(), h}}))
+	assert.Contains(t, res, "# TYPE myapp_rq_time_seconds histogram\n")
+	assert.Contains(t, res, "myapp_rq_time_seconds_bucket{le=\"0.0005\"} 0\n")
+	assert.Contains(t, res, "myapp_rq_time_seconds_bucket{le=\"0.025\"} 1\n")
+	assert.Contains(t, res, "myapp_rq_time_seconds_bucket{le=\"3600\"} 1\n")
+	assert.Contains(t, res, "myapp_rq_time_seconds_sum{} 0.0205\n")
+	// the unit suffix isn't duplicated
+	assert.Contains(t, res, "# TYPE myapp_rq_size_bytes histogram\n")
+	assert.Contains(t, res, "myapp_rq_size_bytes_bucket{le=\"1024\"} 0\n")
+}
+
+func TestFormatHistogramWithBucketsForPrometheus(t *testing.T) {
+	store := stats.NewStore(nil)
+	h := store.CreateScope("").HistogramWithOptions("size", stats.HistogramOptions{
+		// the duplicates and infinities are dropped
+		Buckets: append(stats.LinearBuckets(100, 100, 3), 200, math.Inf(1)),
+	})
+	h.Record(150)
+	h.Record(1000)
+	h.RefreshIntervalStatistics()
+
+	ff := newPrometheusFormatterFactory("myapp")
+	f := ff.Create(nil)
+	res := f.Format(&snapshot{histograms: []*stats.Histogram{h}})
+	expect := `# TYPE myapp_size histogram
+myapp_size_bucket{le="100"} 0
+myapp_size_bucket{le="200"} 1
+myapp_size_bucket{le="300"} 1
+myapp_size_bucket{le="+Inf"} 2
+myapp_size_sum{} 1205
+myapp_size_count{} 2
+`
+	assert.Equal(t, expect, string(res))
+}
+
+func TestFormatSummaryForPrometheus(t *testing.T) {
+	store := stats.NewStore(stats.NewStoreOption().WithHistogramWindow(time.Minute))
+	scope := store.CreateScope("")
+	h1 := scope.HistogramWithOptions("rq_time", stats.HistogramOptions{
+		Quantiles: []float64{0.5, 0.99},
+		Unit:      stats.UnitMilliseconds,
+	}, &stats.Tag{Name: "code", Value: "200"})
+	h2 := scope.Histogram("rq_size")
+	h1.Record(100)
+	h1.RefreshIntervalStatistics()
+	h1.Record(300)
+	h1.RefreshIntervalStatistics()
+	h2.Record(10)
+	h2.RefreshIntervalStatistics()
+
+	t.Run("interval", func(t *testing.T) {
+		ff := newPrometheusFormatterFactoryWithOption("myapp", NewPrometheusOption().WithSummaryNames("rq_time"))
+		f := ff.Create(nil)
+		res := string(f.Format(&snapshot{histograms: []*stats.Histogram{h1, h2}}))
+		expect := `# TYPE myapp_rq_time_seconds summary
+myapp_rq_time_seconds{code="200",quantile="0.5"} 0.305
+myapp_rq_time_seconds{code="200",quantile="0.99"} 0.3099
+myapp_rq_time_seconds_sum{code="200"} 0.41
+myapp_rq_time_seconds_count{code="200"} 2
+`
+		assert.True(t, strings.HasSuffix(res, expect), res)
+		assert.True(t, strings.HasPrefix(res, "# TYPE myapp_rq_size histogram\n"), res)
+	})
+
+	t.Run("window", func(t *testing.T) {
+		ff := newPrometheusFormatterFactoryWithOption("myapp", NewPrometheusOption().
+			WithSummary(true).
+			WithSummaryWindow(time.Minute))
+		f := ff.Create(nil)
+		res := string(f.Format(&snapshot{histograms: []*stats.Histogram{h1, h2}}))
+		assert.Contains(t, res, `myapp_rq_time_seconds{code="200",quantile="0.5"} 0.11`)
+		assert.Contains(t, res, "# TYPE myapp_rq_size summary\n")
+		assert.Contains(t, res, `myapp_rq_size{quantile="0.5"} 10.5`)
+		assert.Contains(t, res, "myapp_rq_size_count{} 1\n")
+
+		// the window of request takes precedence
+		f = ff.Create(&formatOptions{window: time.Nanosecond})
+		res = string(f.Format(&snapshot{histograms: []*stats.Histogram{h1}}))
+		assert.Contains(t, res, `myapp_rq_time_seconds{code="200",quantile="0.5"} 0`+"\n")
+	})
+}
+
+func TestFormatDescriptionForPrometheus(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("rq")
+	defer store.DeleteScope(scope)
+
+	scope.Describe("total", "total requests\nsince \\start", stats.UnitNone)
+	scope.Describe("active", "active requests", stats.UnitNone)
+	scope.Describe("time", "", stats.UnitNone)
+	scope.Counter("total").Inc()
+	scope.Gauge("active").Set(2)
+	scope.Gauge("pending").Set(1)
+	scope.Timer("time").RecordDuration(time.Millisecond)
+
+	f := newPrometheusFormatterFactory("myapp").Create(nil)
+	res := string(f.Format(store))
+	assert.Contains(t, res, "# HELP myapp_rq_total total requests\\nsince \\\\start\n# TYPE myapp_rq_total counter\n")
+	assert.Contains(t, res, "# HELP myapp_rq_active active requests\n# TYPE myapp_rq_active gauge\n")
+	assert.Contains(t, res, "# TYPE myapp_rq_pending gauge\n")
+	assert.NotContains(t, res, "# HELP myapp_rq_pending")
+	// the unit of histogram is the base one of its values
+	assert.Contains(t, res, "# UNIT myapp_rq_time_seconds seconds\n# TYPE myapp_rq_time_seconds histogram\n")
+}
+
+func TestPlainFormatterVerbose(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("rq")
+	defer store.DeleteScope(scope)
+
+	scope.Describe("total", "total requests", stats.UnitNone)
+	scope.Describe("size", "request size", stats.UnitBytes)
+	scope.Counter("total").Inc()
+	scope.Gauge("size").Set(2)
+	scope.Gauge("pending").Set(1)
+
+	f := newPlainFormatterFactory().Create(&formatOptions{verbose: true})
+	res := string(f.Format(store))
+	expect := `rq.pending: 1
+rq.size: 2  # request size [bytes]
+rq.total: 1  # total requests
+`
+	assert.Equal(t, expect, res)
+
+	// the descriptions are hidden by default
+	res = string(newPlainFormatterFactory().Create(nil).Format(store))
+	assert.Contains(t, res, "rq.total: 1\n")
+}
+
+func TestJSONFormatter(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("rq")
+	defer store.DeleteScope(scope)
+
+	c := scope.CounterWithTags("total", &stats.Tag{Name: "code", Value: "200"})
+	c.Add(3)
+	c.Latch()
+	c.Inc()
+	scope.Gauge("active").Set(2)
+	scope.FloatGauge("ratio").Set(0.5)
+	scope.IntGauge("delta").Dec()
+	h := scope.HistogramWithOptions("time", stats.HistogramOptions{
+		Quantiles: []float64{0.5},
+		Buckets:   []float64{10},
+		Unit:      stats.UnitMilliseconds,
+	})
+	h.Record(1)
+	h.RefreshIntervalStatistics()
+	h.Record(100)
+	h.RefreshIntervalStatistics()
+
+	f := newJSONFormatterFactory().Create(nil)
+	assert.Equal(t, "application/json", f.ContentType())
+	expect := `{
+  "stats": [
+    {"name": "rq.active", "type": "gauge", "value": 2},
+    {"name": "rq.delta", "type": "gauge", "value": -1},
+    {"name": "rq.ratio", "type": "gauge", "value": 0.5},
+    {"name": "rq.total", "tags": {"code": "200"}, "type": "counter", "value": 4, "interval": 3}
+  ],
+  "histograms": [
+    {
+      "name": "rq.time",
+      "unit": "milliseconds",
+      "count": {"interval": 1, "cumulative": 2},
+      "sum": {"interval": 105, "cumulative": 106.05},
+      "quantiles": [{"quantile": 0.5, "interval": 105, "cumulative": 1.1}],
+      "buckets": [{"le": 10, "interval": 0, "cumulative": 1}]
+    }
+  ]
+}`
+	res := f.Format(&snapshot{
+		gauges:      store.Gauges(),
+		floatGauges: store.FloatGauges(),
+		intGauges:   store.IntGauges(),
+		counters:    store.Counters(),
+		histograms:  store.Histograms(),
+	})
+	assert.JSONEq(t, expect, string(res))
+}
+
+func TestJSONFormatterNonFinite(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("json")
+	scope.FloatGauge("pos").Set(math.Inf(1))
+	scope.FloatGauge("neg").Set(math.Inf(-1))
+	scope.FloatGauge("nan").Set(math.NaN())
+
+	f := &jsonFormatter{}
+	res, err := f.TryFormat(&snapshot{floatGauges: store.FloatGauges()})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+  "stats": [
+    {"name": "json.nan", "type": "gauge", "value": "NaN"},
+    {"name": "json.neg", "type": "gauge", "value": "-Inf"},
+    {"name": "json.pos", "type": "gauge", "value": "+Inf"}
+  ],
+  "histograms": []
+}`, string(res))
+}
+
+func TestFormatOpenMetrics(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("rq")
+	defer store.DeleteScope(scope)
+
+	scope.Describe("requests", `total "requests"`, stats.UnitNone)
+	c1 := scope.CounterWithTags("requests", &stats.Tag{Name: "code", Value: "200"})
+	c1.Inc()
+	c2 := scope.Counter("errors_total")
+	c2.Add(2)
+	g := scope.Gauge("active")
+	g.Set(3)
+	h := scope.HistogramWithOptions("time", stats.HistogramOptions{
+		Buckets: []float64{10},
+		Unit:    stats.UnitMilliseconds,
+	})
+	h.Record(1)
+	h.RefreshIntervalStatistics()
+
+	created := func(m interface{ CreatedAt() time.Time }) string {
+		return strconv.FormatFloat(float64(m.CreatedAt().UnixNano())/1e9, 'f', 3, 64)
+	}
+	f := newPrometheusFormatterFactory("myapp").Create(&formatOptions{exposition: expositionOpenMetrics})
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", f.ContentType())
+	res := f.Format(&describedSnapshot{
+		snapshot: snapshot{
+			gauges:     []*stats.Gauge{g},
+			counters:   []*stats.Counter{c2, c1},
+			histograms: []*stats.Histogram{h},
+		},
+		Describer: store,
+	})
+	expect := `# TYPE myapp_rq_active gauge
+myapp_rq_active{} 3
+# TYPE myapp_rq_errors counter
+myapp_rq_errors_total{} 2
+myapp_rq_errors_created{} ` + created(c2) + `
+# HELP myapp_rq_requests total \"requests\"
+# TYPE myapp_rq_requests counter
+myapp_rq_requests_total{code="200"} 1
+myapp_rq_requests_created{code="200"} ` + created(c1) + `
+# UNIT myapp_rq_time_seconds seconds
+# TYPE myapp_rq_time_seconds histogram
+myapp_rq_time_seconds_bucket{le="0.01"} 1
+myapp_rq_time_seconds_bucket{le="+Inf"} 1
+myapp_rq_time_seconds_sum{} 0.00105
+myapp_rq_time_seconds_count{} 1
+myapp_rq_time_seconds_created{} ` + created(h) + `
+# EOF
+`
+	assert.Equal(t, expect, string(res))
+}
+
+func TestFormatGroupedFamiliesForPrometheus(t *testing.T) {
+	g1 := stats.NewGauge("foo", "foo", []*stats.Tag{{Name: "tag1", Value: "a"}})
+	g2 := stats.NewIntGauge("foo", "foo", []*stats.Tag{{Name: "tag1", Value: "b"}})
+	g3 := stats.NewGauge("bar", "bar", nil)
+	g1.Set(1)
+	g2.Set(-1)
+	g3.Set(2)
+
+	f := newPrometheusFormatterFactory("myapp").Create(nil)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", f.ContentType())
+	res := f.Format(&snapshot{
+		gauges:    []*stats.Gauge{g1, g3},
+		intGauges: []*stats.IntGauge{g2},
+	})
+	expect := `# TYPE myapp_bar gauge
+myapp_bar{} 2
+# TYPE myapp_foo gauge
+myapp_foo{tag1="a"} 1
+myapp_foo{tag1="b"} -1
+`
+	assert.Equal(t, expect, string(res))
+}
+
+func benchmarkSnapshot(n int) *snapshot {
+	s := new(snapshot)
+	for i := 0; i < n; i++ {
+		tags := []*stats.Tag{
+			{Name: "code", Value: strconv.Itoa(200 + i%5)},
+			{Name: "path", Value: "/api/v" + strconv.Itoa(i)},
+		}
+		c := stats.NewCounter(fmt.Sprintf("rq.total.%d", i), "rq.total", tags)
+		c.Add(uint64(i))
+		s.counters = append(s.counters, c)
+		g := stats.NewGauge(fmt.Sprintf("cx.active.%d", i), "cx.active", tags)
+		g.Set(uint64(i))
+		s.gauges = append(s.gauges, g)
+		h := stats.NewHistogram(nil, fmt.Sprintf("rq.time.%d", i), "rq.time", tags)
+		h.Record(uint64(i))
+		h.RefreshIntervalStatistics()
+		s.histograms = append(s.histograms, h)
+		// formatted as summaries by benchmarkFormatterFactory
+		h = stats.NewHistogram(nil, fmt.Sprintf("rq.size.%d", i), "rq.size", tags)
+		h.Record(uint64(i))
+		h.RefreshIntervalStatistics()
+		s.histograms = append(s.histograms, h)
+	}
+	return s
+}
+
+func benchmarkFormatterFactory() formatterFactory {
+	return newPrometheusFormatterFactoryWithOption("myapp", NewPrometheusOption().WithSummaryNames("rq.size"))
+}
+
+func BenchmarkPrometheusFormatter(b *testing.B) {
+	s := benchmarkSnapshot(1000)
+	ff := benchmarkFormatterFactory()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := ff.Create(nil).(streamFormatter)
+		f.FormatTo(ioutil.Discard, s) //nolint:errcheck
+	}
+}
+
+func BenchmarkOpenMetricsFormatter(b *testing.B) {
+	s := benchmarkSnapshot(1000)
+	ff := benchmarkFormatterFactory()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := ff.Create(&formatOptions{exposition: expositionOpenMetrics}).(streamFormatter)
+		f.FormatTo(ioutil.Discard, s) //nolint:errcheck
+	}
+}
@@ -2,10 +2,13 @@ package http
 
 import (
 	"compress/gzip"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kirk91/stats"
 )
@@ -13,6 +16,7 @@ import (
 const (
 	headerContentEncoding = "Content-Encoding"
 	headerAccpetEncoding  = "Accept-Encoding"
+	headerAccept          = "Accept"
 	headerContentType     = "Content-Type"
 )
 
@@ -23,33 +27,182 @@ func Handler(store *stats.Store) http.Handler {
 }
 
 // PrometheusHandler returns an HTTP handler that shows the metrics
-// by prometheus in the stats store.
+// by prometheus in the stats store. The metrics are presented in
+// OpenMetrics or the delimited protobuf if it's preferred by the Accept
+// header, otherwise in the classic text format.
 func PrometheusHandler(store *stats.Store, namespace string) http.Handler {
 	ff := newPrometheusFormatterFactory(namespace)
 	return newHandler(store, ff)
 }
 
+// PrometheusHandlerWithOption returns an HTTP handler like PrometheusHandler
+// with the given option, e.g. exposing the histograms as summaries.
+func PrometheusHandlerWithOption(store *stats.Store, namespace string, o *PrometheusOption) http.Handler {
+	ff := newPrometheusFormatterFactoryWithOption(namespace, o)
+	return newHandler(store, ff)
+}
+
+// JSONHandler returns an HTTP handler that shows the metrics by JSON in
+// the store.
+func JSONHandler(store *stats.Store) http.Handler {
+	ff := newJSONFormatterFactory()
+	return newHandler(store, ff)
+}
+
 type handler struct {
 	*stats.Store
-	ff formatterFactory
+	ff     formatterFactory
+	jsonFF formatterFactory
 }
 
 func newHandler(store *stats.Store, ff formatterFactory) *handler {
 	return &handler{
-		Store: store,
-		ff:    ff,
+		Store:  store,
+		ff:     ff,
+		jsonFF: newJSONFormatterFactory(),
 	}
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// TODO: add metrics filter
-	formater := h.ff.Create()
-	b := formater.Format(
-		h.Store.Gauges(),
-		h.Store.Counters(),
-		h.Store.Histograms(),
-	)
-	h.write(w, r, b)
+	o, err := parseFormatOptions(r)
+	if err == nil {
+		err = h.checkWindow(o.window)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseMetricsFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ff := h.ff
+	if o.format == formatJSON {
+		ff = h.jsonFF
+	}
+	formater := ff.Create(o)
+	snapshot := newFilteredSnapshot(h.Store, filter)
+	if sf, ok := formater.(streamFormatter); ok {
+		bw, done := h.writer(w, r, sf.ContentType())
+		defer done()
+		sf.FormatTo(bw, snapshot) //nolint:errcheck
+		return
+	}
+	if ff, ok := formater.(fallibleFormatter); ok {
+		b, err := ff.TryFormat(snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.write(w, r, ff.ContentType(), b)
+		return
+	}
+	b := formater.Format(snapshot)
+	h.write(w, r, formater.ContentType(), b)
+}
+
+// parseFormatOptions parses the format options from the query of request.
+// The window query takes the sliding window of histograms, e.g. 5m, which is
+// checked against the HistogramWindow of store by the handler. And the
+// verbose query which takes no value presents the descriptions of metrics.
+// The format query overrides the format of handler, only json is supported.
+func parseFormatOptions(r *http.Request) (*formatOptions, error) {
+	o := new(formatOptions)
+	query := r.URL.Query()
+	switch v := query.Get("format"); v {
+	case "", formatJSON:
+		o.format = v
+	default:
+		return nil, fmt.Errorf("invalid format: %s", v)
+	}
+	if v := query.Get("window"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid window: %s", v)
+		}
+		o.window = window
+	}
+	_, o.verbose = query["verbose"]
+	o.exposition = negotiateExposition(r.Header)
+	return o, nil
+}
+
+// checkWindow checks the window of request against the window retained by
+// histograms, which can't present a longer one.
+func (h *handler) checkWindow(window time.Duration) error {
+	if window <= 0 {
+		return nil
+	}
+	retained := h.HistogramWindow()
+	if retained <= 0 {
+		return fmt.Errorf("invalid window: no histogram window is retained")
+	}
+	if window > retained {
+		return fmt.Errorf("invalid window: %s exceeds the retained %s", window, retained)
+	}
+	return nil
+}
+
+// negotiateExposition returns the exposition format of prometheus
+// preferred by the Accept header, e.g.
+//
+//	Accept: application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1
+//
+// The classic text format is returned if none is acceptable.
+func negotiateExposition(header http.Header) exposition {
+	var qs [expositionProtobuf + 1]float64
+	for _, part := range strings.Split(header.Get(headerAccept), ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		var version, proto, encoding string
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch strings.ToLower(kv[0]) {
+			case "q":
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			case "version":
+				version = kv[1]
+			case "proto":
+				proto = kv[1]
+			case "encoding":
+				encoding = kv[1]
+			}
+		}
+
+		e := exposition(-1)
+		switch mediaType {
+		case "application/vnd.google.protobuf":
+			if proto == "io.prometheus.client.MetricFamily" && encoding == "delimited" {
+				e = expositionProtobuf
+			}
+		case "application/openmetrics-text":
+			// only 1.0.0 is supported
+			if version == "" || version == "1.0.0" {
+				e = expositionOpenMetrics
+			}
+		case "text/plain", "text/*", "*/*":
+			e = expositionText
+		}
+		if e >= 0 && q > qs[e] {
+			qs[e] = q
+		}
+	}
+
+	// the later one wins in a tie, which is more efficient
+	res := expositionText
+	for _, e := range []exposition{expositionOpenMetrics, expositionProtobuf} {
+		if qs[e] > 0 && qs[e] >= qs[res] {
+			res = e
+		}
+	}
+	return res
 }
 
 var gzipPool = sync.Pool{
@@ -58,24 +211,31 @@ var gzipPool = sync.Pool{
 	},
 }
 
-func (h *handler) write(rw http.ResponseWriter, req *http.Request, b []byte) {
-	w := io.Writer(rw)
+func (h *handler) write(rw http.ResponseWriter, req *http.Request, contentType string, b []byte) {
+	w, done := h.writer(rw, req, contentType)
+	defer done()
+	w.Write(b) //nolint:errcheck
+}
 
-	// check if accept gzip encoding
-	if gzipAccepted(req.Header) {
-		gw := gzipPool.Get().(*gzip.Writer)
-		defer gzipPool.Put(gw)
+// writer sets the headers of response and returns the writer of body, which
+// is compressed by gzip if it's accepted. The returned func must be called
+// after writing to flush the body.
+func (h *handler) writer(rw http.ResponseWriter, req *http.Request, contentType string) (io.Writer, func()) {
+	// set content-type
+	rw.Header().Set(headerContentType, contentType)
 
-		gw.Reset(w)
-		defer gw.Close()
-		w = gw
-		// set content-encoding
-		rw.Header().Set(headerContentEncoding, "gzip")
+	// check if accept gzip encoding
+	if !gzipAccepted(req.Header) {
+		return rw, func() {}
+	}
+	gw := gzipPool.Get().(*gzip.Writer)
+	gw.Reset(rw)
+	// set content-encoding
+	rw.Header().Set(headerContentEncoding, "gzip")
+	return gw, func() {
+		gw.Close()
+		gzipPool.Put(gw)
 	}
-
-	// set content-type
-	rw.Header().Set(headerContentType, "text/plain")
-	w.Write(b) //nolint:errcheck
 }
 
 func gzipAccepted(header http.Header) bool {
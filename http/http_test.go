@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kirk91/stats"
 	"github.com/stretchr/testify/assert"
@@ -38,6 +40,182 @@ func TestPlainHandler(t *testing.T) {
 	assert.Contains(t, string(data), fmt.Sprintf("%s: %d", gauge1.Name(), gauge1.Value()))
 }
 
+func TestHandlerGaugeFunc(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("pool")
+	defer store.DeleteScope(scope)
+
+	var size uint64 = 1
+	scope.GaugeFunc("size", func() uint64 { return size })
+
+	ts := httptest.NewServer(Handler(store))
+	defer ts.Close()
+
+	get := func() string {
+		res, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	assert.Contains(t, get(), "pool.size: 1\n")
+	size = 7
+	assert.Contains(t, get(), "pool.size: 7\n")
+}
+
+func TestHandlerWindow(t *testing.T) {
+	store := stats.NewStore(stats.NewStoreOption().WithHistogramWindow(time.Minute))
+	scope := store.CreateScope("rq")
+	defer store.DeleteScope(scope)
+
+	h := scope.Histogram("size")
+	h.Record(100)
+	h.RefreshIntervalStatistics()
+	h.Record(200)
+	h.RefreshIntervalStatistics()
+
+	ts := httptest.NewServer(Handler(store))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "?window=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(data), "rq.size: P0(100,100)")
+
+	for _, window := range []string{"foo", "2m"} {
+		res, err = http.Get(ts.URL + "?window=" + window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+
+	// no window is retained
+	ts = httptest.NewServer(Handler(stats.NewStore(nil)))
+	defer ts.Close()
+	res, err = http.Get(ts.URL + "?window=5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid window: no histogram window is retained\n", string(data))
+}
+
+func TestPlainHandlerVerbose(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("plain")
+	defer store.DeleteScope(scope)
+
+	scope.Describe("counter1", "the first counter", stats.UnitNone)
+	scope.Counter("counter1").Inc()
+
+	ts := httptest.NewServer(Handler(store))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "?verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(data), "plain.counter1: 1  # the first counter\n")
+}
+
+func TestHandlerFilter(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("filter")
+	defer store.DeleteScope(scope)
+
+	scope.Counter("rq_total").Inc()
+	scope.Gauge("cx_active").Inc()
+
+	get := func(ts *httptest.Server, query string) (int, string) {
+		res, err := http.Get(ts.URL + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(data)
+	}
+
+	ts := httptest.NewServer(Handler(store))
+	defer ts.Close()
+	code, body := get(ts, "filter=rq_&scope=filter")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "filter.rq_total: 1\n", body)
+	code, _ = get(ts, "type=foo")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	pts := httptest.NewServer(PrometheusHandler(store, "myapp"))
+	defer pts.Close()
+	code, body = get(pts, "type=gauge&prefix=filter.")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "# TYPE myapp_filter_cx_active gauge\nmyapp_filter_cx_active{} 1\n", body)
+	code, _ = get(pts, "filter=(")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestJSONHandler(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("json")
+	defer store.DeleteScope(scope)
+
+	scope.Counter("counter1").Inc()
+
+	for _, ts := range []*httptest.Server{
+		httptest.NewServer(JSONHandler(store)),
+		httptest.NewServer(PrometheusHandler(store, "myapp")),
+	} {
+		res, err := http.Get(ts.URL + "?format=json&filter=json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"stats": [{"name": "json.counter1", "type": "counter", "value": 1, "interval": 0}],
+			"histograms": []
+		}`, string(data))
+
+		res, err = http.Get(ts.URL + "?format=xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		ts.Close()
+	}
+}
+
 func TestPrometheusHandler(t *testing.T) {
 	store := stats.NewStore(nil)
 	scope := store.CreateScope("prometheus")
@@ -67,6 +245,80 @@ func TestPrometheusHandler(t *testing.T) {
 	assert.Contains(t, body, "myapp_prometheus_counter1{} 1\n")
 }
 
+func TestPrometheusHandlerNegotiation(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("om")
+	defer store.DeleteScope(scope)
+
+	scope.Counter("counter1").Inc()
+
+	ts := httptest.NewServer(PrometheusHandler(store, "myapp"))
+	defer ts.Close()
+
+	get := func(accept string) (string, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"?scope=om", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Header.Get("Content-Type"), string(data)
+	}
+
+	contentType, body := get("application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", contentType)
+	assert.Contains(t, body, "myapp_om_counter1_total{} 1\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+
+	contentType, body = get("application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	assert.Equal(t, contentTypeProtobuf, contentType)
+	assert.Equal(t, "myapp_om_counter1", decodeDelimited(t, []byte(body))[0].string(1))
+
+	contentType, body = get("")
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", contentType)
+	assert.Equal(t, "# TYPE myapp_om_counter1 counter\nmyapp_om_counter1{} 1\n", body)
+}
+
+func TestNegotiateExposition(t *testing.T) {
+	const protobuf = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited"
+	tests := []struct {
+		accept   string
+		expected exposition
+	}{
+		{"", expositionText},
+		{"*/*", expositionText},
+		{"text/plain;version=0.0.4", expositionText},
+		{"application/openmetrics-text", expositionOpenMetrics},
+		{"application/openmetrics-text;version=1.0.0", expositionOpenMetrics},
+		{"application/openmetrics-text;version=0.0.1", expositionText},
+		{"application/openmetrics-text;version=1.0.0;q=0.5,text/plain;q=0.8", expositionText},
+		{"application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75," +
+			"text/plain;version=0.0.4;q=0.5,*/*;q=0.1", expositionOpenMetrics},
+		{"application/openmetrics-text;q=0", expositionText},
+		{protobuf, expositionProtobuf},
+		{"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily", expositionText},
+		{protobuf + ";q=0.7,application/openmetrics-text;version=1.0.0;q=0.8", expositionOpenMetrics},
+		{protobuf + ",application/openmetrics-text;version=1.0.0,*/*", expositionProtobuf},
+	}
+
+	for i, test := range tests {
+		name := fmt.Sprintf("case %d", i+1)
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Accept", test.accept)
+			assert.Equal(t, test.expected, negotiateExposition(header))
+		})
+	}
+}
+
 func TestGzipAccpeted(t *testing.T) {
 	tests := []struct {
 		acceptEncoding string
@@ -100,7 +352,7 @@ func TestHandlerWrite(t *testing.T) {
 		b := []byte("hello, world")
 
 		h := &handler{}
-		h.write(rw, req, b)
+		h.write(rw, req, contentTypeText, b)
 		assert.Equal(t, "text/plain", rw.Header().Get("content-type"))
 		assert.Equal(t, b, rw.Body.Bytes())
 	})
@@ -114,7 +366,7 @@ func TestHandlerWrite(t *testing.T) {
 		b := []byte("hello, world")
 
 		h := &handler{}
-		h.write(rw, req, b)
+		h.write(rw, req, contentTypeText, b)
 		assert.Equal(t, "text/plain", rw.Header().Get("content-type"))
 
 		// decode response
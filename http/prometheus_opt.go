@@ -0,0 +1,40 @@
+package http
+
+import "time"
+
+// PrometheusOption contains options of the prometheus handler.
+type PrometheusOption struct {
+	// Summary indicates whether all the histograms are exposed as summaries
+	// with quantiles instead of buckets.
+	Summary bool
+	// SummaryNames are the tag extracted names of the histograms exposed as
+	// summaries, it takes effect when Summary is false.
+	SummaryNames []string
+	// SummaryWindow is the sliding window which the quantiles of summaries are
+	// computed over, zero means the last flush interval. It's capped by the
+	// HistogramWindow of store.
+	SummaryWindow time.Duration
+}
+
+// NewPrometheusOption creates a PrometheusOption which exposes the histograms as is.
+func NewPrometheusOption() *PrometheusOption {
+	return &PrometheusOption{}
+}
+
+// WithSummary returns a PrometheusOption that exposes all the histograms as summaries.
+func (opt *PrometheusOption) WithSummary(summary bool) *PrometheusOption {
+	opt.Summary = summary
+	return opt
+}
+
+// WithSummaryNames returns a PrometheusOption that exposes the named histograms as summaries.
+func (opt *PrometheusOption) WithSummaryNames(names ...string) *PrometheusOption {
+	opt.SummaryNames = names
+	return opt
+}
+
+// WithSummaryWindow returns a PrometheusOption that sets the sliding window of summaries.
+func (opt *PrometheusOption) WithSummaryWindow(window time.Duration) *PrometheusOption {
+	opt.SummaryWindow = window
+	return opt
+}
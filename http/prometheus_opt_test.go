@@ -0,0 +1,22 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusOption(t *testing.T) {
+	opt := NewPrometheusOption()
+	assert.False(t, opt.Summary)
+	assert.Empty(t, opt.SummaryNames)
+	assert.Zero(t, opt.SummaryWindow)
+
+	opt.WithSummary(true).
+		WithSummaryNames("rq_time").
+		WithSummaryWindow(time.Minute)
+	assert.True(t, opt.Summary)
+	assert.Equal(t, []string{"rq_time"}, opt.SummaryNames)
+	assert.Equal(t, time.Minute, opt.SummaryWindow)
+}
@@ -0,0 +1,322 @@
+package http
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kirk91/stats"
+	"github.com/kirk91/stats/internal/expfmt"
+)
+
+// the metric types of io.prometheus.client.MetricType.
+const (
+	protoMetricTypeCounter   = 0
+	protoMetricTypeGauge     = 1
+	protoMetricTypeSummary   = 2
+	protoMetricTypeHistogram = 4
+)
+
+// protobufFormatter formats the metrics to the length delimited protobuf
+// messages of io.prometheus.client.MetricFamily, which is cheaper to
+// produce and parse than the text.
+// Refer to https://github.com/prometheus/client_model/blob/master/io/prometheus/client/metrics.proto
+type protobufFormatter struct {
+	*prometheusFormatter
+
+	// the metric families and the metrics of them are sorted by name and
+	// labels like the text.
+	families map[string]*protoFamily
+	scratch  protoBuffer
+	labels   []expfmt.Label // the scratch of the labels of a metric
+}
+
+// protoFamily is a metric family, whose metrics are encoded on adding.
+type protoFamily struct {
+	name    string
+	help    string
+	unit    string
+	typ     uint64
+	metrics []*protoMetric
+}
+
+type protoMetric struct {
+	labels string // the formatted labels to sort by
+	name   string // the original name to order the colliding ones
+	buf    []byte // the encoded metric field
+}
+
+func newProtobufFormatter(pf *prometheusFormatter) *protobufFormatter {
+	return &protobufFormatter{
+		prometheusFormatter: pf,
+		families:            make(map[string]*protoFamily),
+	}
+}
+
+func (f *protobufFormatter) Format(snapshot stats.MetricsSnapshot) []byte {
+	f.describer, _ = snapshot.(stats.Describer)
+
+	for _, gauge := range snapshot.Gauges() {
+		f.formatGauge(gauge, float64(gauge.Value()))
+	}
+	for _, gauge := range snapshot.FloatGauges() {
+		f.formatGauge(gauge, gauge.Value())
+	}
+	for _, gauge := range snapshot.IntGauges() {
+		f.formatGauge(gauge, float64(gauge.Value()))
+	}
+	for _, counter := range snapshot.Counters() {
+		f.formatCounter(counter)
+	}
+	for _, histogram := range snapshot.Histograms() {
+		f.formatHistogram(histogram)
+	}
+
+	names := make([]string, 0, len(f.families))
+	for name := range f.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var res, msg protoBuffer
+	for _, name := range names {
+		family := f.families[name]
+		msg.reset()
+		msg.stringField(1, family.name)
+		if family.help != "" {
+			msg.stringField(2, family.help)
+		}
+		msg.uint64Field(3, family.typ)
+		sort.SliceStable(family.metrics, func(i, j int) bool {
+			a, b := family.metrics[i], family.metrics[j]
+			if a.labels != b.labels {
+				return a.labels < b.labels
+			}
+			return a.name < b.name
+		})
+		for i, m := range family.metrics {
+			// drop the duplicated series like the text
+			if i > 0 && family.metrics[i-1].labels == m.labels {
+				continue
+			}
+			msg.bytes(m.buf)
+		}
+		if family.unit != "" {
+			msg.stringField(5, family.unit)
+		}
+		res.varint(uint64(len(msg.buf)))
+		res.bytes(msg.buf)
+	}
+	return res.buf
+}
+
+// FormatTo writes the formatted metric families to w, it overrides the one
+// of the text.
+func (f *protobufFormatter) FormatTo(w io.Writer, snapshot stats.MetricsSnapshot) error {
+	_, err := w.Write(f.Format(snapshot))
+	return err
+}
+
+func (*protobufFormatter) ContentType() string {
+	return contentTypeProtobuf
+}
+
+func (f *protobufFormatter) formatGauge(m exposedMetric, value float64) {
+	name := f.prefix + m.SanitizedName()
+	family := f.family(name, protoMetricTypeGauge, m.TagExtractedName(), stats.UnitNone)
+	f.addMetric(family, m, "", 2, func(b *protoBuffer) {
+		b.doubleField(1, value)
+	})
+}
+
+func (f *protobufFormatter) formatCounter(c *stats.Counter) {
+	// the family keeps the series name of the text format, the client
+	// libraries of prometheus do the same with the protobuf exposition.
+	name := f.prefix + c.SanitizedName()
+	family := f.family(name, protoMetricTypeCounter, c.TagExtractedName(), stats.UnitNone)
+	f.addMetric(family, c, "", 3, func(b *protoBuffer) {
+		b.doubleField(1, float64(c.Value()))
+		b.timestampField(3, c.CreatedAt())
+	})
+}
+
+func (f *protobufFormatter) formatHistogram(h *stats.Histogram) {
+	unit := h.Unit()
+	name, suffix := h.SanitizedName(), unitSuffix(unit.Base())
+	if !strings.HasSuffix(name, suffix) {
+		name += suffix
+	}
+	name = f.prefix + name
+
+	if f.isSummary(h) {
+		family := f.family(name, protoMetricTypeSummary, h.TagExtractedName(), unit.Base())
+		sqs := h.SupportedQuantiles()
+		var cqs []float64
+		if f.window > 0 {
+			cqs = h.WindowStatistics(f.window).ComputedQuantiles()
+		} else {
+			f.quantiles = h.AppendIntervalQuantiles(f.quantiles[:0])
+			cqs = f.quantiles
+		}
+		count, sum := h.CumulativeCountAndSum()
+		f.addMetric(family, h, "quantile", 4, func(b *protoBuffer) {
+			b.uint64Field(1, count)
+			b.doubleField(2, unit.ToBase(sum))
+			for i := 0; i < len(sqs); i++ {
+				b.messageField(3, func(b *protoBuffer) {
+					b.doubleField(1, sqs[i])
+					b.doubleField(2, unit.ToBase(cqs[i]))
+				})
+			}
+			b.timestampField(4, h.CreatedAt())
+		})
+		return
+	}
+
+	family := f.family(name, protoMetricTypeHistogram, h.TagExtractedName(), unit.Base())
+	sbs := h.SupportedBuckets()
+	cbs, count, sum := h.AppendCumulativeBuckets(f.counts[:0])
+	f.counts = cbs
+	f.addMetric(family, h, "le", 7, func(b *protoBuffer) {
+		b.uint64Field(1, count)
+		b.doubleField(2, unit.ToBase(sum))
+		// the +Inf bucket is implied by the sample count.
+		for i := 0; i < len(sbs); i++ {
+			b.messageField(3, func(b *protoBuffer) {
+				b.uint64Field(1, cbs[i])
+				b.doubleField(2, unit.ToBase(sbs[i]))
+			})
+		}
+		b.timestampField(15, h.CreatedAt())
+	})
+}
+
+// family returns the metric family of the name, the unit is the one of the
+// exposed values, UnitNone means taking the one from the description. It
+// returns nil if the family is of another type.
+func (f *protobufFormatter) family(name string, typ uint64, extractedName string, unit stats.Unit) *protoFamily {
+	if family, ok := f.families[name]; ok {
+		if family.typ != typ {
+			return nil
+		}
+		return family
+	}
+	family := &protoFamily{name: name, typ: typ}
+	desc, _ := f.description(extractedName)
+	family.help = desc.Help
+	if unit == stats.UnitNone {
+		unit = desc.Unit
+	}
+	// the unit must be the suffix of family name like OpenMetrics.
+	if unit != stats.UnitNone && strings.HasSuffix(name, "_"+unit.String()) {
+		family.unit = unit.String()
+	}
+	f.families[name] = family
+	return family
+}
+
+// addMetric adds a metric with the labels to the family, the value of which
+// is encoded by fn as the given field. The reserved label, e.g. le of
+// histogram, is dropped.
+func (f *protobufFormatter) addMetric(family *protoFamily, metric exposedMetric, reserved string,
+	field int, fn func(*protoBuffer)) {
+	if family == nil {
+		return
+	}
+	f.scratch.reset()
+	f.labels = appendLabels(f.labels[:0], metric.Tags(), reserved)
+	for _, l := range f.labels {
+		f.scratch.messageField(1, func(b *protoBuffer) {
+			b.stringField(1, l.Name)
+			b.stringField(2, l.Value)
+		})
+	}
+	f.scratch.messageField(field, fn)
+
+	var m protoBuffer
+	m.key(4, protoWireBytes)
+	m.varint(uint64(len(f.scratch.buf)))
+	m.bytes(f.scratch.buf)
+	family.metrics = append(family.metrics, &protoMetric{
+		labels: metricLabels(metric, reserved),
+		name:   metric.TagExtractedName(),
+		buf:    m.buf,
+	})
+}
+
+// the wire types of protobuf.
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+// protoBuffer is a minimal protobuf encoder, which only supports the wire
+// types used by the metric families.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) reset() {
+	b.buf = b.buf[:0]
+}
+
+func (b *protoBuffer) bytes(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.buf = append(b.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	b.buf = append(b.buf, byte(v))
+}
+
+func (b *protoBuffer) key(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64Field(field int, v uint64) {
+	b.key(field, protoWireVarint)
+	b.varint(v)
+}
+
+func (b *protoBuffer) doubleField(field int, v float64) {
+	b.key(field, protoWireFixed64)
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], math.Float64bits(v))
+	b.buf = append(b.buf, p[:]...)
+}
+
+func (b *protoBuffer) stringField(field int, s string) {
+	b.key(field, protoWireBytes)
+	b.varint(uint64(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+// messageField encodes the embedded message by fn.
+func (b *protoBuffer) messageField(field int, fn func(*protoBuffer)) {
+	var msg protoBuffer
+	fn(&msg)
+	b.key(field, protoWireBytes)
+	b.varint(uint64(len(msg.buf)))
+	b.bytes(msg.buf)
+}
+
+// timestampField encodes the time as google.protobuf.Timestamp, the zero
+// time is omitted.
+func (b *protoBuffer) timestampField(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	b.messageField(field, func(b *protoBuffer) {
+		b.uint64Field(1, uint64(t.Unix()))
+		if nanos := t.Nanosecond(); nanos > 0 {
+			b.uint64Field(2, uint64(nanos))
+		}
+	})
+}
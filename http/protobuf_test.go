@@ -0,0 +1,191 @@
+package http
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/kirk91/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+// protoMessage is a decoded protobuf message, which maps the field number
+// to the values.
+type protoMessage map[int][]interface{}
+
+// decodeProto decodes the message, the values of length delimited fields
+// are kept as []byte.
+func decodeProto(t *testing.T, b []byte) protoMessage {
+	msg := make(protoMessage)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("invalid key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case protoWireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("invalid varint")
+			}
+			b = b[n:]
+			msg[field] = append(msg[field], v)
+		case protoWireFixed64:
+			msg[field] = append(msg[field], math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			b = b[8:]
+		case protoWireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("invalid length")
+			}
+			b = b[n:]
+			msg[field] = append(msg[field], b[:l])
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return msg
+}
+
+func (m protoMessage) message(t *testing.T, field, i int) protoMessage {
+	return decodeProto(t, m[field][i].([]byte))
+}
+
+func (m protoMessage) string(field int) string {
+	return string(m[field][0].([]byte))
+}
+
+// decodeDelimited decodes the length delimited messages.
+func decodeDelimited(t *testing.T, b []byte) []protoMessage {
+	var res []protoMessage
+	for len(b) > 0 {
+		l, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("invalid length")
+		}
+		b = b[n:]
+		res = append(res, decodeProto(t, b[:l]))
+		b = b[l:]
+	}
+	return res
+}
+
+func TestProtoBuffer(t *testing.T) {
+	var b protoBuffer
+	b.uint64Field(1, 150)
+	assert.Equal(t, []byte{0x08, 0x96, 0x01}, b.buf)
+
+	b.reset()
+	b.stringField(2, "testing")
+	assert.Equal(t, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}, b.buf)
+
+	b.reset()
+	b.doubleField(1, 1)
+	assert.Equal(t, []byte{0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}, b.buf)
+
+	b.reset()
+	b.messageField(3, func(b *protoBuffer) { b.uint64Field(1, 150) })
+	assert.Equal(t, []byte{0x1a, 0x03, 0x08, 0x96, 0x01}, b.buf)
+}
+
+func TestFormatProtobuf(t *testing.T) {
+	store := stats.NewStore(nil)
+	scope := store.CreateScope("rq")
+	defer store.DeleteScope(scope)
+
+	scope.Describe("total", "total requests", stats.UnitNone)
+	c1 := scope.CounterWithTags("total", &stats.Tag{Name: "code", Value: "200"})
+	c1.Add(2)
+	c2 := scope.CounterWithTags("total", &stats.Tag{Name: "code", Value: "500"})
+	c2.Inc()
+	g := scope.IntGauge("delta")
+	g.Dec()
+	h := scope.HistogramWithOptions("time", stats.HistogramOptions{
+		Buckets: []float64{10, 10, math.Inf(1)},
+		Unit:    stats.UnitMilliseconds,
+	})
+	h.Record(1)
+	h.RefreshIntervalStatistics()
+	s := scope.Histogram("size")
+	s.Record(10)
+	s.RefreshIntervalStatistics()
+
+	ff := newPrometheusFormatterFactoryWithOption("myapp", NewPrometheusOption().WithSummaryNames("rq.size"))
+	f := ff.Create(&formatOptions{exposition: expositionProtobuf})
+	assert.Equal(t, contentTypeProtobuf, f.ContentType())
+	families := decodeDelimited(t, f.Format(&describedSnapshot{
+		snapshot: snapshot{
+			intGauges:  []*stats.IntGauge{g},
+			counters:   []*stats.Counter{c1, c2},
+			histograms: []*stats.Histogram{h, s},
+		},
+		Describer: store,
+	}))
+	assert.Equal(t, 4, len(families))
+
+	// the families are sorted by name
+	family := families[0]
+	assert.Equal(t, "myapp_rq_delta", family.string(1))
+	assert.Nil(t, family[2])
+	assert.Equal(t, uint64(protoMetricTypeGauge), family[3][0])
+	assert.Equal(t, -1.0, family.message(t, 4, 0).message(t, 2, 0)[1][0])
+
+	// counters are grouped into a family named as the series of the text
+	family = families[3]
+	assert.Equal(t, "myapp_rq_total", family.string(1))
+	assert.Equal(t, "total requests", family.string(2))
+	assert.Equal(t, uint64(protoMetricTypeCounter), family[3][0])
+	assert.Equal(t, 2, len(family[4]))
+	metric := family.message(t, 4, 1)
+	label := metric.message(t, 1, 0)
+	assert.Equal(t, "code", label.string(1))
+	assert.Equal(t, "500", label.string(2))
+	counter := metric.message(t, 3, 0)
+	assert.Equal(t, 1.0, counter[1][0])
+	created := counter.message(t, 3, 0)
+	assert.Equal(t, uint64(c2.CreatedAt().Unix()), created[1][0])
+
+	// histogram
+	family = families[2]
+	assert.Equal(t, "myapp_rq_time_seconds", family.string(1))
+	assert.Equal(t, uint64(protoMetricTypeHistogram), family[3][0])
+	assert.Equal(t, "seconds", family.string(5))
+	hist := family.message(t, 4, 0).message(t, 7, 0)
+	assert.Equal(t, uint64(1), hist[1][0])
+	assert.InDelta(t, 0.00105, hist[2][0], 1e-9)
+	assert.Equal(t, 1, len(hist[3]))
+	bucket := hist.message(t, 3, 0)
+	assert.Equal(t, uint64(1), bucket[1][0])
+	assert.Equal(t, 0.01, bucket[2][0])
+	assert.NotNil(t, hist[15])
+
+	// summary
+	family = families[1]
+	assert.Equal(t, "myapp_rq_size", family.string(1))
+	assert.Equal(t, uint64(protoMetricTypeSummary), family[3][0])
+	summary := family.message(t, 4, 0).message(t, 4, 0)
+	assert.Equal(t, uint64(1), summary[1][0])
+	assert.Equal(t, 7, len(summary[3]))
+	quantile := summary.message(t, 3, 2)
+	assert.Equal(t, 0.5, quantile[1][0])
+	assert.Equal(t, 10.5, quantile[2][0])
+}
+
+func TestFormatProtobufCollidingNames(t *testing.T) {
+	c1 := stats.NewCounter("x.rq.total", "x.rq.total", nil)
+	c1.Add(3)
+	c2 := stats.NewCounter("x.rq_total", "x.rq_total", nil)
+	c2.Add(4)
+
+	f := newPrometheusFormatterFactory("myapp").Create(&formatOptions{exposition: expositionProtobuf})
+	// the snapshot isn't ordered
+	families := decodeDelimited(t, f.Format(&snapshot{counters: []*stats.Counter{c2, c1}}))
+	assert.Equal(t, 1, len(families))
+	assert.Equal(t, "myapp_x_rq_total", families[0].string(1))
+	// only the series of the least original name is kept
+	assert.Equal(t, 1, len(families[0][4]))
+	assert.Equal(t, 3.0, families[0].message(t, 4, 0).message(t, 3, 0)[1][0])
+}
@@ -0,0 +1,95 @@
+// Package expfmt implements the naming rules of the exposition formats
+// like prometheus, which are shared by the metrics and the http formatters.
+package expfmt
+
+import (
+	"strings"
+)
+
+// SanitizeName replaces the characters of name not matching [a-zA-Z0-9_]
+// with underscores, which makes it valid in the exposition formats like
+// prometheus. Refer to https://prometheus.io/docs/concepts/data_model/
+func SanitizeName(name string) string {
+	valid := true
+	for _, r := range name {
+		if !isNameChar(r) {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return name
+	}
+
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name {
+		if isNameChar(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func isNameChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
+// SanitizeLabelName sanitizes the name of tag as a label name, which mustn't
+// start with a digit. So do the metric names, the namespaces of which could
+// be sanitized by it as well.
+func SanitizeLabelName(name string) string {
+	name = SanitizeName(name)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// labelValueEscaper escapes the backslash, double quote and line feed in
+// the label value.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Label is a label of the exposition formats, which is converted from a tag.
+type Label struct {
+	Name  string // the sanitized name
+	Value string // the raw value, which isn't escaped
+}
+
+// AppendLabel appends the tag of name and value to labels as a label. The
+// tags of the same sanitized name are deduplicated, the later one takes
+// precedence, and the reserved one, e.g. le of histogram, is dropped.
+func AppendLabel(labels []Label, name, value, reserved string) []Label {
+	name = SanitizeLabelName(name)
+	if name == reserved {
+		return labels
+	}
+	for i := range labels {
+		if labels[i].Name == name {
+			labels[i].Value = value
+			return labels
+		}
+	}
+	return append(labels, Label{Name: name, Value: value})
+}
+
+// FormatLabels formats the labels, e.g. code="200",path="/". The values are
+// escaped.
+func FormatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
@@ -0,0 +1,37 @@
+package expfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "rq_total", SanitizeName("rq_total"))
+	assert.Equal(t, "rq_time_p99", SanitizeName("rq.time-p99"))
+	assert.Equal(t, "rq__", SanitizeName("rq.é"))
+	assert.Equal(t, "", SanitizeName(""))
+}
+
+func TestFormatLabels(t *testing.T) {
+	format := func(reserved string, tags ...string) string {
+		var labels []Label
+		for i := 0; i < len(tags); i += 2 {
+			labels = AppendLabel(labels, tags[i], tags[i+1], reserved)
+		}
+		return FormatLabels(labels)
+	}
+	assert.Equal(t, "", format(""))
+	assert.Equal(t, `code="200",path="/"`, format("", "code", "200", "path", "/"))
+	assert.Equal(t, `_1st="a\\b\"c\nd"`, format("", "1st", "a\\b\"c\nd"))
+	// the later one of the same sanitized name takes precedence
+	assert.Equal(t, `up_stream="b",code="200"`, format("", "up.stream", "a", "code", "200", "up-stream", "b"))
+	// the reserved one is dropped
+	assert.Equal(t, `code="200"`, format("le", "le", "x", "code", "200"))
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "code", SanitizeLabelName("code"))
+	assert.Equal(t, "_1st", SanitizeLabelName("1st"))
+	assert.Equal(t, "_", SanitizeLabelName(""))
+}
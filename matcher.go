@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// StringMatcher specifies how a metric name is matched, exactly one of
+// the fields must be set.
+type StringMatcher struct {
+	Exact  string
+	Prefix string
+	Suffix string
+	Regex  string
+}
+
+type stringMatcher struct {
+	exact  string
+	prefix string
+	suffix string
+	re     *regexp.Regexp
+}
+
+func newStringMatcher(m StringMatcher) (*stringMatcher, error) {
+	n := 0
+	for _, field := range []string{m.Exact, m.Prefix, m.Suffix, m.Regex} {
+		if field != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, errors.New("exactly one of exact, prefix, suffix and regex must be set")
+	}
+
+	sm := &stringMatcher{
+		exact:  m.Exact,
+		prefix: m.Prefix,
+		suffix: m.Suffix,
+	}
+	if m.Regex != "" {
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return nil, err
+		}
+		sm.re = re
+	}
+	return sm, nil
+}
+
+func (m *stringMatcher) match(s string) bool {
+	switch {
+	case m.exact != "":
+		return s == m.exact
+	case m.prefix != "":
+		return strings.HasPrefix(s, m.prefix)
+	case m.suffix != "":
+		return strings.HasSuffix(s, m.suffix)
+	case m.re != nil:
+		return m.re.MatchString(s)
+	}
+	return false
+}
+
+// StatsMatcher determines whether a metric should be instantiated by its name.
+// The metric is rejected if the inclusion list is not empty and none of them
+// matches, or any of the exclusion list matches.
+type StatsMatcher struct {
+	inclusions []*stringMatcher
+	exclusions []*stringMatcher
+}
+
+// NewStatsMatcher creates a stats matcher with the inclusion and exclusion lists.
+func NewStatsMatcher(inclusions, exclusions []StringMatcher) (*StatsMatcher, error) {
+	m := new(StatsMatcher)
+	for _, inclusion := range inclusions {
+		sm, err := newStringMatcher(inclusion)
+		if err != nil {
+			return nil, err
+		}
+		m.inclusions = append(m.inclusions, sm)
+	}
+	for _, exclusion := range exclusions {
+		sm, err := newStringMatcher(exclusion)
+		if err != nil {
+			return nil, err
+		}
+		m.exclusions = append(m.exclusions, sm)
+	}
+	return m, nil
+}
+
+// Rejects reports whether the metric with the given name should be rejected.
+func (m *StatsMatcher) Rejects(name string) bool {
+	if m == nil {
+		return false
+	}
+	if len(m.inclusions) > 0 && !matchAny(m.inclusions, name) {
+		return true
+	}
+	return matchAny(m.exclusions, name)
+}
+
+func matchAny(matchers []*stringMatcher, s string) bool {
+	for _, m := range matchers {
+		if m.match(s) {
+			return true
+		}
+	}
+	return false
+}
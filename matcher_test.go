@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatsMatcherWithInvalidRule(t *testing.T) {
+	_, err := NewStatsMatcher([]StringMatcher{{}}, nil)
+	assert.Error(t, err)
+
+	_, err = NewStatsMatcher(nil, []StringMatcher{{Exact: "a", Prefix: "b"}})
+	assert.Error(t, err)
+
+	_, err = NewStatsMatcher(nil, []StringMatcher{{Regex: "(a"}})
+	assert.Error(t, err)
+}
+
+func TestStatsMatcherRejects(t *testing.T) {
+	var nilMatcher *StatsMatcher
+	assert.False(t, nilMatcher.Rejects("foo"))
+
+	m, err := NewStatsMatcher(
+		[]StringMatcher{
+			{Prefix: "upstream."},
+			{Suffix: ".rq_total"},
+		},
+		[]StringMatcher{
+			{Exact: "upstream.debug"},
+			{Regex: `^upstream\.\d+\.`},
+		},
+	)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		rejected bool
+	}{
+		{"upstream.cx_active", false},
+		{"listener.rq_total", false},
+		{"listener.cx_active", true},
+		{"upstream.debug", true},
+		{"upstream.123.cx_active", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.rejected, m.Rejects(test.name), test.name)
+	}
+
+	m, err = NewStatsMatcher(nil, []StringMatcher{{Prefix: "runtime."}})
+	assert.NoError(t, err)
+	assert.False(t, m.Rejects("listener.cx_active"))
+	assert.True(t, m.Rejects("runtime.num_gc"))
+}
@@ -1,7 +1,11 @@
 package stats
 
 import (
+	"math"
 	"sync/atomic"
+	"time"
+
+	"github.com/kirk91/stats/internal/expfmt"
 )
 
 // Metric is a general interface for stats.
@@ -14,22 +18,80 @@ type Metric interface {
 
 var (
 	_ Metric = new(Gauge)
+	_ Metric = new(FloatGauge)
+	_ Metric = new(IntGauge)
 	_ Metric = new(Histogram)
 	_ Metric = new(Counter)
 )
 
+// the usage states of metric.
+const (
+	metricUnused  int32 = iota
+	metricIdle          // used but not touched since the last idle check
+	metricTouched       // touched since the last idle check
+	metricEvicted       // evicted from the scope, revived on the next update
+)
+
 type metric struct {
 	name             string
+	key              string
 	tagExtractedName string
 	tags             []*Tag
-	isUsed           int32
+	sanitizedName    string // the tag extracted name sanitized for exposition
+	labels           string // the tags formatted as the labels of exposition
+	created          time.Time
+	state            int32
+	idleChecks       uint32 // only accessed by the idle checker
+	null             bool   // all the updates on a null metric are discarded
+	revive           func() // registers the evicted metric to its scope again
 }
 
+// the null metrics are returned for the rejected names, they are never
+// flushed or rendered.
+var (
+	nullGauge      = &Gauge{metric: metric{null: true}}
+	nullFloatGauge = &FloatGauge{metric: metric{null: true}}
+	nullIntGauge   = &IntGauge{metric: metric{null: true}}
+	nullCounter    = &Counter{metric: metric{null: true}}
+	nullHistogram  = newHistogram(nil, metric{null: true}, HistogramOptions{})
+)
+
 func newMetric(name, tagExtractedName string, tags []*Tag) metric {
 	return metric{
 		name:             name,
 		tagExtractedName: tagExtractedName,
 		tags:             tags,
+		sanitizedName:    expfmt.SanitizeName(tagExtractedName),
+		labels:           formatLabels(tags),
+		created:          time.Now(),
+	}
+}
+
+// formatLabels formats the tags as the labels of the exposition formats.
+func formatLabels(tags []*Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	labels := make([]expfmt.Label, 0, len(tags))
+	for _, tag := range tags {
+		labels = expfmt.AppendLabel(labels, tag.Name, tag.Value, "")
+	}
+	return expfmt.FormatLabels(labels)
+}
+
+// clone returns a read-only copy of the metric, which is used to make
+// the snapshots for sinks.
+func (m *metric) clone() metric {
+	return metric{
+		name:             m.name,
+		key:              m.key,
+		tagExtractedName: m.tagExtractedName,
+		tags:             m.tags,
+		sanitizedName:    m.sanitizedName,
+		labels:           m.labels,
+		created:          m.created,
+		state:            atomic.LoadInt32(&m.state),
+		null:             true,
 	}
 }
 
@@ -37,6 +99,15 @@ func (m *metric) Name() string {
 	return m.name
 }
 
+// Key returns the identity of the metric, it is composed by the name and
+// the explicitly attached tags, e.g. upstream.rq_total{cluster=foo}.
+func (m *metric) Key() string {
+	if m.key == "" {
+		return m.name
+	}
+	return m.key
+}
+
 func (m *metric) TagExtractedName() string {
 	return m.tagExtractedName
 }
@@ -45,12 +116,59 @@ func (m *metric) Tags() []*Tag {
 	return m.tags
 }
 
+// SanitizedName returns the tag extracted name with the characters invalid
+// in the exposition formats like prometheus replaced by underscores, which
+// is cached to format the exposition efficiently.
+func (m *metric) SanitizedName() string {
+	return m.sanitizedName
+}
+
+// FormattedLabels returns the tags formatted as the labels of exposition
+// formats like prometheus, e.g. code="200",path="/". The label names are
+// sanitized and the values are escaped.
+func (m *metric) FormattedLabels() string {
+	return m.labels
+}
+
+// CreatedAt returns when the metric was created, which is exposed as the
+// created timestamp by OpenMetrics.
+func (m *metric) CreatedAt() time.Time {
+	return m.created
+}
+
 func (m *metric) IsUsed() bool {
-	return atomic.LoadInt32(&m.isUsed) == 1
+	return atomic.LoadInt32(&m.state) != metricUnused
 }
 
 func (m *metric) markUsed() {
-	atomic.StoreInt32(&m.isUsed, 1)
+	if atomic.SwapInt32(&m.state, metricTouched) == metricEvicted && m.revive != nil {
+		m.revive()
+	}
+}
+
+// evict marks the idle metric as evicted, it fails if the metric has been
+// touched meanwhile.
+func (m *metric) evict() bool {
+	for {
+		state := atomic.LoadInt32(&m.state)
+		if state == metricTouched {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&m.state, state, metricEvicted) {
+			return true
+		}
+	}
+}
+
+// checkIdle reports whether the metric hasn't been touched for the
+// given number of consecutive checks.
+func (m *metric) checkIdle(maxChecks uint32) bool {
+	if atomic.CompareAndSwapInt32(&m.state, metricTouched, metricIdle) {
+		m.idleChecks = 0
+		return false
+	}
+	m.idleChecks++
+	return m.idleChecks >= maxChecks
 }
 
 // Gauge is a Metric that represents a single numerical value that can
@@ -58,6 +176,7 @@ func (m *metric) markUsed() {
 type Gauge struct {
 	metric
 	val uint64
+	fn  atomic.Value // func() uint64
 }
 
 // NewGauge creates a gauge with given params.
@@ -70,18 +189,27 @@ func NewGauge(name, tagExtractedName string, tags []*Tag) *Gauge {
 
 // Set sets the gauge to an arbitrary value.
 func (g *Gauge) Set(val uint64) {
+	if g.null {
+		return
+	}
 	atomic.StoreUint64(&g.val, val)
 	g.markUsed()
 }
 
 // Add adds the given value to the Gauge.
 func (g *Gauge) Add(amount uint64) {
+	if g.null {
+		return
+	}
 	atomic.AddUint64(&g.val, amount)
 	g.markUsed()
 }
 
 // Sub subtracts the given value from the Gauge.
 func (g *Gauge) Sub(amount uint64) {
+	if g.null {
+		return
+	}
 	atomic.AddUint64(&g.val, ^uint64(amount-1))
 	g.markUsed()
 }
@@ -96,11 +224,161 @@ func (g *Gauge) Dec() {
 	g.Sub(1)
 }
 
+// SetFunc binds a callback to the Gauge, it will be invoked to obtain the
+// value every time the Gauge is read, e.g. when the store flushes or the
+// metrics are rendered over HTTP. Values set by Set/Add/Sub are ignored
+// once a callback is bound.
+func (g *Gauge) SetFunc(fn func() uint64) {
+	if g.null {
+		return
+	}
+	g.fn.Store(fn)
+	g.markUsed()
+}
+
+func (g *Gauge) hasFunc() bool {
+	return g.fn.Load() != nil
+}
+
 // Value returns the Gauge value.
 func (g *Gauge) Value() uint64 {
+	if fn, ok := g.fn.Load().(func() uint64); ok {
+		return fn()
+	}
 	return atomic.LoadUint64(&g.val)
 }
 
+// FloatGauge is a Metric that represents a single floating-point value
+// that can arbitrarily go up and down, e.g. ratios or load averages.
+type FloatGauge struct {
+	metric
+	bits uint64       // the IEEE 754 representation of the value
+	fn   atomic.Value // func() float64
+}
+
+// NewFloatGauge creates a float gauge with given params.
+// NOTE: It should only be used in unit tests.
+func NewFloatGauge(name, tagExtractedName string, tags []*Tag) *FloatGauge {
+	return &FloatGauge{
+		metric: newMetric(name, tagExtractedName, tags),
+	}
+}
+
+// Set sets the gauge to an arbitrary value.
+func (g *FloatGauge) Set(val float64) {
+	if g.null {
+		return
+	}
+	atomic.StoreUint64(&g.bits, math.Float64bits(val))
+	g.markUsed()
+}
+
+// Add adds the given value to the FloatGauge, the value could be negative.
+func (g *FloatGauge) Add(delta float64) {
+	if g.null {
+		return
+	}
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		val := math.Float64frombits(old) + delta
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(val)) {
+			break
+		}
+	}
+	g.markUsed()
+}
+
+// Sub subtracts the given value from the FloatGauge.
+func (g *FloatGauge) Sub(delta float64) {
+	g.Add(-delta)
+}
+
+// Inc increments the FloatGauge by 1.
+func (g *FloatGauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the FloatGauge by 1.
+func (g *FloatGauge) Dec() {
+	g.Add(-1)
+}
+
+// SetFunc binds a callback to the FloatGauge, it will be invoked to obtain
+// the value every time the FloatGauge is read. Values set by Set/Add/Sub are
+// ignored once a callback is bound.
+func (g *FloatGauge) SetFunc(fn func() float64) {
+	if g.null {
+		return
+	}
+	g.fn.Store(fn)
+	g.markUsed()
+}
+
+func (g *FloatGauge) hasFunc() bool {
+	return g.fn.Load() != nil
+}
+
+// Value returns the FloatGauge value.
+func (g *FloatGauge) Value() float64 {
+	if fn, ok := g.fn.Load().(func() float64); ok {
+		return fn()
+	}
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// IntGauge is a Metric that represents a single signed integer value
+// that can arbitrarily go up and down, even below zero.
+type IntGauge struct {
+	metric
+	val int64
+}
+
+// NewIntGauge creates a signed gauge with given params.
+// NOTE: It should only be used in unit tests.
+func NewIntGauge(name, tagExtractedName string, tags []*Tag) *IntGauge {
+	return &IntGauge{
+		metric: newMetric(name, tagExtractedName, tags),
+	}
+}
+
+// Set sets the gauge to an arbitrary value.
+func (g *IntGauge) Set(val int64) {
+	if g.null {
+		return
+	}
+	atomic.StoreInt64(&g.val, val)
+	g.markUsed()
+}
+
+// Add adds the given value to the IntGauge, the value could be negative.
+func (g *IntGauge) Add(delta int64) {
+	if g.null {
+		return
+	}
+	atomic.AddInt64(&g.val, delta)
+	g.markUsed()
+}
+
+// Sub subtracts the given value from the IntGauge.
+func (g *IntGauge) Sub(delta int64) {
+	g.Add(-delta)
+}
+
+// Inc increments the IntGauge by 1.
+func (g *IntGauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the IntGauge by 1.
+func (g *IntGauge) Dec() {
+	g.Add(-1)
+}
+
+// Value returns the IntGauge value.
+func (g *IntGauge) Value() int64 {
+	return atomic.LoadInt64(&g.val)
+}
+
 // Counter is a Metric that represents a single numerical value
 // that only ever goes up. Each increment is added both to a global
 // counter as well as periodic counter.
@@ -120,6 +398,9 @@ func NewCounter(name, tagExtractedName string, tags []*Tag) *Counter {
 
 // Add adds the given value to the counter.
 func (c *Counter) Add(amount uint64) {
+	if c.null {
+		return
+	}
 	atomic.AddUint64(&c.val, amount)
 	atomic.AddUint64(&c.pendingIncr, amount)
 	c.markUsed()
@@ -2,6 +2,7 @@ package stats
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,6 +32,72 @@ func TestGauge(t *testing.T) {
 	assert.Equal(t, g.Value(), uint64(1))
 }
 
+func TestGaugeFunc(t *testing.T) {
+	g := NewGauge("pool_size", "pool_size", nil)
+	assert.False(t, g.IsUsed())
+
+	var size uint64 = 3
+	g.SetFunc(func() uint64 { return size })
+	assert.True(t, g.IsUsed())
+	assert.Equal(t, uint64(3), g.Value())
+
+	size = 5
+	assert.Equal(t, uint64(5), g.Value())
+
+	// the pushed value is ignored once a callback is bound
+	g.Set(1)
+	assert.Equal(t, uint64(5), g.Value())
+}
+
+func TestFloatGaugeFunc(t *testing.T) {
+	g := NewFloatGauge("hit_ratio", "hit_ratio", nil)
+	g.SetFunc(func() float64 { return 0.9 })
+	assert.True(t, g.IsUsed())
+	assert.Equal(t, 0.9, g.Value())
+}
+
+func TestFloatGauge(t *testing.T) {
+	g := NewFloatGauge("ratio", "ratio", nil)
+	assert.Equal(t, g.Name(), "ratio")
+	assert.False(t, g.IsUsed())
+
+	g.Set(0.5)
+	assert.Equal(t, 0.5, g.Value())
+	assert.True(t, g.IsUsed())
+
+	g.Inc()
+	assert.Equal(t, 1.5, g.Value())
+
+	g.Add(0.25)
+	assert.Equal(t, 1.75, g.Value())
+
+	g.Sub(2)
+	assert.Equal(t, -0.25, g.Value())
+
+	g.Dec()
+	assert.Equal(t, -1.25, g.Value())
+}
+
+func TestIntGauge(t *testing.T) {
+	g := NewIntGauge("delta", "delta", nil)
+	assert.Equal(t, g.Name(), "delta")
+
+	g.Dec()
+	assert.Equal(t, int64(-1), g.Value())
+
+	g.Add(3)
+	assert.Equal(t, int64(2), g.Value())
+
+	g.Sub(5)
+	assert.Equal(t, int64(-3), g.Value())
+
+	g.Inc()
+	assert.Equal(t, int64(-2), g.Value())
+
+	g.Set(7)
+	assert.Equal(t, int64(7), g.Value())
+}
+
 func TestCounter(t *testing.T) {
 	name := "mong"
 	tagExtractedName := "mo"
@@ -52,3 +119,20 @@ func TestCounter(t *testing.T) {
 	assert.Equal(t, uint64(5), c.Value())
 	assert.Equal(t, uint64(2), c.IntervalValue())
 }
+
+func TestMetricCreatedAt(t *testing.T) {
+	before := time.Now()
+	c := NewCounter("foo", "foo", nil)
+	assert.False(t, c.CreatedAt().Before(before))
+	assert.False(t, c.CreatedAt().After(time.Now()))
+
+	// the copies keep the created timestamp
+	clone := c.clone()
+	assert.Equal(t, c.CreatedAt(), clone.CreatedAt())
+}
+
+func TestMetricSanitizedNameAndLabels(t *testing.T) {
+	c := NewCounter("rq.total.code.200", "rq.total", []*Tag{{Name: "code", Value: "200"}})
+	assert.Equal(t, "rq_total", c.SanitizedName())
+	assert.Equal(t, `code="200"`, c.FormattedLabels())
+}
@@ -8,27 +8,65 @@ import (
 // Scope is a grouping of stats.
 type Scope struct {
 	prefix   string
+	tags     []*Tag // sorted by name, attached to every metric within the scope
 	store    *Store
 	refCount uint
 
+	parent   *Scope
+	childKey string // the key within the children of parent
+
+	numMetricsVal int64
+	unlimited     int32 // exempt from the cardinality limits
+	deleted       bool  // guarded by the locks of counters and histograms
+
+	// the metrics count against the limit of quota, which is the scope itself
+	// or the one sharing the prefix that the scope is tagged from.
+	quota    *Scope
+	quotaVal int64 // the number of metrics counting against the scope
+
+	// the overflow scope stands for the children rejected by the limits, the
+	// metrics of it all resolve to the overflow ones of its quota.
+	overflow      bool
+	overflowOnce  sync.Once
+	overflowChild *Scope
+
+	rejectedLock   sync.Mutex
+	rejected       atomic.Value // map[string]struct{}, names rejected by the stats matcher
+	overflowedLock sync.Mutex
+	overflowedKeys atomic.Value // *overflowedKeys, keys rejected by the limits
+
 	childLock sync.RWMutex
 	children  atomic.Value // map[string]*Scope
 
-	gaugesLock     sync.Mutex
-	gauges         atomic.Value // map[string]*Gauge, key is the metric's name without prefix
-	countersLock   sync.Mutex
-	counters       atomic.Value // map[string]*Counter
-	histogramsLock sync.Mutex
-	histograms     atomic.Value // map[string]*Histogram
+	gaugesLock      sync.Mutex
+	gauges          atomic.Value // map[string]*Gauge, key is the metric's name without prefix plus explicit tags
+	floatGaugesLock sync.Mutex
+	floatGauges     atomic.Value // map[string]*FloatGauge
+	intGaugesLock   sync.Mutex
+	intGauges       atomic.Value // map[string]*IntGauge
+	countersLock    sync.Mutex
+	counters        atomic.Value // map[string]*Counter
+	histogramsLock  sync.Mutex
+	histograms      atomic.Value // map[string]*Histogram
 }
 
 func newScope(name string, store *Store) *Scope {
+	return newTaggedScope(name, nil, store)
+}
+
+func newTaggedScope(name string, tags []*Tag, store *Store) *Scope {
 	s := &Scope{
 		prefix: name,
+		tags:   tags,
 		store:  store,
 	}
+	s.quota = s
 	s.children.Store(make(map[string]*Scope))
+	s.rejected.Store(make(map[string]struct{}))
+	s.overflowedKeys.Store(new(overflowedKeys))
 	s.gauges.Store(make(map[string]*Gauge))
+	s.floatGauges.Store(make(map[string]*FloatGauge))
+	s.intGauges.Store(make(map[string]*IntGauge))
 	s.counters.Store(make(map[string]*Counter))
 	s.histograms.Store(make(map[string]*Histogram))
 	return s
@@ -36,33 +74,92 @@ func newScope(name string, store *Store) *Scope {
 
 // NewChild returns a new child scope
 func (scope *Scope) NewChild(name string) *Scope {
+	if scope.overflow {
+		return scope
+	}
 	children := scope.loadChildren()
 	if child, ok := children[name]; ok {
 		return child
 	}
+	if scope.store.metricsExhausted() {
+		return scope.overflowScope()
+	}
 
 	scope.childLock.Lock()
-	child := scope.newChildLocked(name)
-	scope.childLock.Unlock()
-	return child
+	defer scope.childLock.Unlock()
+	return scope.newChildLocked(name, scope.prefix+name, scope.tags)
 }
 
-func (scope *Scope) newChildLocked(name string) *Scope {
+// Tagged returns a child scope sharing the same prefix, the given tags
+// will be attached to every metric created within it and its children.
+func (scope *Scope) Tagged(tags map[string]string) *Scope {
+	if len(tags) == 0 || scope.overflow {
+		return scope
+	}
+	tagged := mergeTags(scope.tags, tagsFromMap(tags))
+	sortTags(tagged)
+	key := tagsString(tagged)
+	if key == tagsString(scope.tags) {
+		return scope
+	}
 	children := scope.loadChildren()
-	if child, ok := children[name]; ok {
+	if child, ok := children[key]; ok {
+		return child
+	}
+	// the tagged children share the limit of scope
+	if scope.store.limitReached(scope) {
+		return scope.overflowScope()
+	}
+
+	scope.childLock.Lock()
+	defer scope.childLock.Unlock()
+	return scope.newChildLocked(key, scope.prefix, tagged)
+}
+
+// overflowScope returns the scope standing for the children rejected by the
+// limits, which isn't registered in the store.
+func (scope *Scope) overflowScope() *Scope {
+	scope.overflowOnce.Do(func() {
+		child := newTaggedScope(scope.prefix, scope.tags, scope.store)
+		child.quota = scope.quota
+		child.overflow = true
+		scope.overflowChild = child
+	})
+	return scope.overflowChild
+}
+
+func (scope *Scope) newChildLocked(key, name string, tags []*Tag) *Scope {
+	children := scope.loadChildren()
+	if child, ok := children[key]; ok {
 		return child
 	}
 
 	tmp := make(map[string]*Scope, len(children))
-	child := scope.store.CreateScope(scope.prefix + name)
-	tmp[name] = child
-	for name, child := range children {
-		tmp[name] = child
+	child := scope.store.createScope(name, tags, scope, key)
+	tmp[key] = child
+	for key, child := range children {
+		tmp[key] = child
 	}
 	scope.updateChildren(tmp)
 	return child
 }
 
+func (scope *Scope) removeChild(key string, child *Scope) {
+	scope.childLock.Lock()
+	defer scope.childLock.Unlock()
+
+	children := scope.loadChildren()
+	if c, ok := children[key]; !ok || c != child {
+		return
+	}
+	tmp := make(map[string]*Scope, len(children))
+	for key, c := range children {
+		tmp[key] = c
+	}
+	delete(tmp, key)
+	scope.updateChildren(tmp)
+}
+
 func (scope *Scope) loadChildren() map[string]*Scope {
 	return scope.children.Load().(map[string]*Scope)
 }
@@ -76,6 +173,140 @@ func (scope *Scope) Name() string {
 	return scope.prefix
 }
 
+// Tags returns the tags attached to the scope.
+func (scope *Scope) Tags() []*Tag {
+	return scope.tags
+}
+
+// isRejected reports whether the name has been rejected by the stats matcher.
+func (scope *Scope) isRejected(name string) bool {
+	_, ok := scope.rejected.Load().(map[string]struct{})[name]
+	return ok
+}
+
+// rejects reports whether the name should be rejected by the stats matcher,
+// the rejected name will be remembered to avoid matching again.
+func (scope *Scope) rejects(name string) bool {
+	if name == overflowMetricName || !scope.store.matcher.Rejects(scope.prefix+name) {
+		return false
+	}
+
+	scope.rejectedLock.Lock()
+	defer scope.rejectedLock.Unlock()
+	rejected := scope.rejected.Load().(map[string]struct{})
+	tmp := make(map[string]struct{}, len(rejected)+1)
+	for name := range rejected {
+		tmp[name] = struct{}{}
+	}
+	tmp[name] = struct{}{}
+	scope.rejected.Store(tmp)
+	return true
+}
+
+// the kinds of metrics, which tell apart the overflowed keys.
+const (
+	kindGauge byte = iota
+	kindFloatGauge
+	kindIntGauge
+	kindCounter
+	kindHistogram
+)
+
+// maxOverflowedKeys is the max number of keys remembered by a scope, the
+// keys beyond it are resolved to the overflow metrics without being counted.
+const maxOverflowedKeys = 1024
+
+// overflowedKeys is the keys rejected by the limits since the releases of
+// store, it's outdated once any metric is released.
+type overflowedKeys struct {
+	releases int64
+	keys     map[overflowedKey]struct{}
+}
+
+type overflowedKey struct {
+	kind byte
+	key  string
+}
+
+// isOverflowed reports whether the metric of key resolves to the overflow
+// one, it doesn't take any lock.
+func (scope *Scope) isOverflowed(kind byte, key string) bool {
+	o := scope.overflowedKeys.Load().(*overflowedKeys)
+	if o.releases != scope.store.loadReleases() {
+		return false
+	}
+	if _, ok := o.keys[overflowedKey{kind, key}]; ok {
+		return true
+	}
+	return len(o.keys) >= maxOverflowedKeys && scope.store.limitReached(scope)
+}
+
+// overflowed remembers the key rejected by the limits and counts it, so that
+// the later lookups of it take the fast path and aren't counted again.
+func (scope *Scope) overflowed(kind byte, key string) {
+	scope.overflowedLock.Lock()
+	defer scope.overflowedLock.Unlock()
+
+	releases := scope.store.loadReleases()
+	o := scope.overflowedKeys.Load().(*overflowedKeys)
+	if o.releases != releases {
+		o = &overflowedKeys{releases: releases}
+	}
+	k := overflowedKey{kind, key}
+	if _, ok := o.keys[k]; ok {
+		return
+	}
+	scope.store.getStatsScope().Counter("cardinality_rejected").Inc()
+	if len(o.keys) >= maxOverflowedKeys {
+		scope.overflowedKeys.Store(o)
+		return
+	}
+	tmp := &overflowedKeys{
+		releases: releases,
+		keys:     make(map[overflowedKey]struct{}, len(o.keys)+1),
+	}
+	for k := range o.keys {
+		tmp.keys[k] = struct{}{}
+	}
+	tmp.keys[k] = struct{}{}
+	scope.overflowedKeys.Store(tmp)
+}
+
+func (scope *Scope) addMetrics(n int64) {
+	atomic.AddInt64(&scope.numMetricsVal, n)
+	atomic.AddInt64(&scope.quota.quotaVal, n)
+}
+
+// quotaMetrics returns the number of metrics counting against the limit of
+// the scope.
+func (scope *Scope) quotaMetrics() uint64 {
+	return uint64(atomic.LoadInt64(&scope.quotaVal))
+}
+
+// numMetrics returns the number of metrics within the scope.
+func (scope *Scope) numMetrics() uint64 {
+	return uint64(atomic.LoadInt64(&scope.numMetricsVal))
+}
+
+// key returns the identity of the scope within the store.
+func (scope *Scope) key() string {
+	return scope.prefix + tagsString(scope.tags)
+}
+
+// newMetric builds the metric identity for the given name and explicit tags,
+// the tags must be sorted by name.
+func (scope *Scope) newMetric(name string, tags []*Tag) metric {
+	finalName := scope.prefix + name
+	extractedName, producedTags := scope.store.getTagsForName(finalName)
+	explicitTags := mergeTags(scope.tags, tags)
+	if len(tags) > 0 {
+		sortTags(explicitTags)
+	}
+	m := newMetric(finalName, extractedName, mergeTags(producedTags, explicitTags))
+	m.key = finalName + tagsString(explicitTags)
+	return m
+}
+
 func (scope *Scope) loadGauges() map[string]*Gauge {
 	return scope.gauges.Load().(map[string]*Gauge)
 }
@@ -84,6 +315,22 @@ func (scope *Scope) updateGauges(v map[string]*Gauge) {
 	scope.gauges.Store(v)
 }
 
+func (scope *Scope) loadFloatGauges() map[string]*FloatGauge {
+	return scope.floatGauges.Load().(map[string]*FloatGauge)
+}
+
+func (scope *Scope) updateFloatGauges(v map[string]*FloatGauge) {
+	scope.floatGauges.Store(v)
+}
+
+func (scope *Scope) loadIntGauges() map[string]*IntGauge {
+	return scope.intGauges.Load().(map[string]*IntGauge)
+}
+
+func (scope *Scope) updateIntGauges(v map[string]*IntGauge) {
+	scope.intGauges.Store(v)
+}
+
 func (scope *Scope) loadCounters() map[string]*Counter {
 	return scope.counters.Load().(map[string]*Counter)
 }
@@ -102,100 +349,341 @@ func (scope *Scope) updateHistograms(v map[string]*Histogram) {
 
 // Gauge returns a gauge within the scope namespace.
 func (scope *Scope) Gauge(name string) *Gauge {
+	return scope.GaugeWithTags(name)
+}
+
+// GaugeWithTags returns a gauge within the scope namespace with the given
+// tags attached. Gauges with the same name but different tags are distinct.
+func (scope *Scope) GaugeWithTags(name string, tags ...*Tag) *Gauge {
 	// TODO(kik91): sanitize name
+	tags, key := metricKey(name, tags)
 	gs := scope.loadGauges()
-	if g, ok := gs[name]; ok {
+	if g, ok := gs[key]; ok {
 		return g
 	}
+	if scope.isRejected(name) {
+		return nullGauge
+	}
+	if scope.isOverflowed(kindGauge, key) {
+		return scope.quota.Gauge(overflowMetricName)
+	}
 
 	scope.gaugesLock.Lock()
-	g := scope.gaugeLocked(name)
+	g := scope.gaugeLocked(key, name, tags)
 	scope.gaugesLock.Unlock()
 	return g
 }
 
-func (scope *Scope) gaugeLocked(name string) *Gauge {
+// GaugeFunc returns a gauge within the scope namespace whose value is
+// obtained by invoking fn when the metrics are flushed or rendered.
+func (scope *Scope) GaugeFunc(name string, fn func() uint64) *Gauge {
+	g := scope.Gauge(name)
+	g.SetFunc(fn)
+	return g
+}
+
+func (scope *Scope) gaugeLocked(key, name string, tags []*Tag) *Gauge {
 	gs := scope.loadGauges()
-	if g, ok := gs[name]; ok {
+	if g, ok := gs[key]; ok {
 		return g
 	}
 
+	if scope.rejects(name) {
+		return nullGauge
+	}
+	if !scope.store.acquireMetric(scope, key) {
+		scope.overflowed(kindGauge, key)
+		if scope.quota != scope {
+			return scope.quota.Gauge(overflowMetricName)
+		}
+		return scope.gaugeLocked(overflowMetricName, overflowMetricName, nil)
+	}
+
 	tmp := make(map[string]*Gauge, len(gs))
+	for key, g := range gs {
+		tmp[key] = g
+	}
+	g := &Gauge{metric: scope.newMetric(name, tags)}
+	tmp[key] = g
+	scope.updateGauges(tmp)
+	return g
+}
+
+// FloatGauge returns a float gauge within the scope namespace.
+func (scope *Scope) FloatGauge(name string) *FloatGauge {
+	gs := scope.loadFloatGauges()
+	if g, ok := gs[name]; ok {
+		return g
+	}
+	if scope.isRejected(name) {
+		return nullFloatGauge
+	}
+	if scope.isOverflowed(kindFloatGauge, name) {
+		return scope.quota.FloatGauge(overflowMetricName)
+	}
+
+	scope.floatGaugesLock.Lock()
+	g := scope.floatGaugeLocked(name)
+	scope.floatGaugesLock.Unlock()
+	return g
+}
+
+// FloatGaugeFunc returns a float gauge within the scope namespace whose
+// value is obtained by invoking fn when the metrics are flushed or rendered.
+func (scope *Scope) FloatGaugeFunc(name string, fn func() float64) *FloatGauge {
+	g := scope.FloatGauge(name)
+	g.SetFunc(fn)
+	return g
+}
+
+func (scope *Scope) floatGaugeLocked(name string) *FloatGauge {
+	gs := scope.loadFloatGauges()
+	if g, ok := gs[name]; ok {
+		return g
+	}
+
+	if scope.rejects(name) {
+		return nullFloatGauge
+	}
+	if !scope.store.acquireMetric(scope, name) {
+		scope.overflowed(kindFloatGauge, name)
+		if scope.quota != scope {
+			return scope.quota.FloatGauge(overflowMetricName)
+		}
+		return scope.floatGaugeLocked(overflowMetricName)
+	}
+
+	tmp := make(map[string]*FloatGauge, len(gs))
 	for name, g := range gs {
 		tmp[name] = g
 	}
-	finalName := scope.prefix + name
-	extractedName, tags := scope.store.getTagsForName(finalName)
-	g := NewGauge(finalName, extractedName, tags)
+	g := &FloatGauge{metric: scope.newMetric(name, nil)}
 	tmp[name] = g
-	scope.updateGauges(tmp)
+	scope.updateFloatGauges(tmp)
+	return g
+}
+
+// IntGauge returns a signed gauge within the scope namespace.
+func (scope *Scope) IntGauge(name string) *IntGauge {
+	gs := scope.loadIntGauges()
+	if g, ok := gs[name]; ok {
+		return g
+	}
+	if scope.isRejected(name) {
+		return nullIntGauge
+	}
+	if scope.isOverflowed(kindIntGauge, name) {
+		return scope.quota.IntGauge(overflowMetricName)
+	}
+
+	scope.intGaugesLock.Lock()
+	g := scope.intGaugeLocked(name)
+	scope.intGaugesLock.Unlock()
+	return g
+}
+
+func (scope *Scope) intGaugeLocked(name string) *IntGauge {
+	gs := scope.loadIntGauges()
+	if g, ok := gs[name]; ok {
+		return g
+	}
+
+	if scope.rejects(name) {
+		return nullIntGauge
+	}
+	if !scope.store.acquireMetric(scope, name) {
+		scope.overflowed(kindIntGauge, name)
+		if scope.quota != scope {
+			return scope.quota.IntGauge(overflowMetricName)
+		}
+		return scope.intGaugeLocked(overflowMetricName)
+	}
+
+	tmp := make(map[string]*IntGauge, len(gs))
+	for name, g := range gs {
+		tmp[name] = g
+	}
+	g := &IntGauge{metric: scope.newMetric(name, nil)}
+	tmp[name] = g
+	scope.updateIntGauges(tmp)
 	return g
 }
 
 // Counter returns a counter within the scope namespace.
 func (scope *Scope) Counter(name string) *Counter {
+	return scope.CounterWithTags(name)
+}
+
+// CounterWithTags returns a counter within the scope namespace with the given
+// tags attached. Counters with the same name but different tags are distinct.
+func (scope *Scope) CounterWithTags(name string, tags ...*Tag) *Counter {
 	// TODO(kik91): sanitize name
+	tags, key := metricKey(name, tags)
 	cs := scope.loadCounters()
-	if c, ok := cs[name]; ok {
+	if c, ok := cs[key]; ok {
 		return c
 	}
+	if scope.isRejected(name) {
+		return nullCounter
+	}
+	if scope.isOverflowed(kindCounter, key) {
+		return scope.quota.Counter(overflowMetricName)
+	}
 
 	scope.countersLock.Lock()
-	c := scope.counterLocked(name)
+	c := scope.counterLocked(key, name, tags)
 	scope.countersLock.Unlock()
 	return c
 }
 
-func (scope *Scope) counterLocked(name string) *Counter {
+func (scope *Scope) counterLocked(key, name string, tags []*Tag) *Counter {
 	cs := scope.loadCounters()
-	if c, ok := cs[name]; ok {
+	if c, ok := cs[key]; ok {
 		return c
 	}
 
+	if scope.rejects(name) {
+		return nullCounter
+	}
+	if !scope.store.acquireMetric(scope, key) {
+		scope.overflowed(kindCounter, key)
+		if scope.quota != scope {
+			return scope.quota.Counter(overflowMetricName)
+		}
+		return scope.counterLocked(overflowMetricName, overflowMetricName, nil)
+	}
+
 	tmp := make(map[string]*Counter, len(cs))
-	for name, c := range cs {
-		tmp[name] = c
+	for key, c := range cs {
+		tmp[key] = c
 	}
-	finalName := scope.prefix + name
-	extractedName, tags := scope.store.getTagsForName(finalName)
-	c := NewCounter(finalName, extractedName, tags)
-	tmp[name] = c
+	c := &Counter{metric: scope.newMetric(name, tags)}
+	c.revive = func() { scope.reviveCounter(key, c) }
+	tmp[key] = c
 	scope.updateCounters(tmp)
 	return c
 }
 
+// reviveCounter registers the evicted counter again unless the scope has been
+// deleted. If the counter has been created again meanwhile, the revived one
+// takes over and the other is evicted.
+func (scope *Scope) reviveCounter(key string, c *Counter) {
+	scope.countersLock.Lock()
+	defer scope.countersLock.Unlock()
+	if scope.deleted {
+		return
+	}
+
+	cs := scope.loadCounters()
+	if old, ok := cs[key]; ok {
+		old.evict()
+	} else {
+		scope.store.reclaimMetric(scope, key)
+	}
+	tmp := make(map[string]*Counter, len(cs)+1)
+	for key, c := range cs {
+		tmp[key] = c
+	}
+	tmp[key] = c
+	scope.updateCounters(tmp)
+}
+
 // Histogram returns a histogram within the scope namespace.
 func (scope *Scope) Histogram(name string) *Histogram {
+	return scope.HistogramWithTags(name)
+}
+
+// HistogramWithTags returns a histogram within the scope namespace with the given
+// tags attached. Histograms with the same name but different tags are distinct.
+func (scope *Scope) HistogramWithTags(name string, tags ...*Tag) *Histogram {
+	return scope.histogram(name, HistogramOptions{}, tags)
+}
+
+// HistogramWithOptions returns a histogram within the scope namespace with the
+// given options, e.g. the buckets and quantiles. The options are only applied
+// when the histogram is created. It panics if any quantile is not within [0, 1]
+// or any bucket is NaN.
+func (scope *Scope) HistogramWithOptions(name string, o HistogramOptions, tags ...*Tag) *Histogram {
+	o.validate()
+	return scope.histogram(name, o, tags)
+}
+
+// Timer returns a timer within the scope namespace, the durations are recorded
+// by the histogram of the same name in milliseconds.
+func (scope *Scope) Timer(name string) Timer {
+	return Timer{h: scope.HistogramWithOptions(name, HistogramOptions{Unit: UnitMilliseconds})}
+}
+
+func (scope *Scope) histogram(name string, o HistogramOptions, tags []*Tag) *Histogram {
 	// TODO(kik91): sanitize name
+	tags, key := metricKey(name, tags)
 	hs := scope.loadHistograms()
-	if h, ok := hs[name]; ok {
+	if h, ok := hs[key]; ok {
 		return h
 	}
+	if scope.isRejected(name) {
+		return nullHistogram
+	}
+	if scope.isOverflowed(kindHistogram, key) {
+		return scope.quota.Histogram(overflowMetricName)
+	}
 
 	scope.histogramsLock.Lock()
-	h := scope.histogramLocked(name)
+	h := scope.histogramLocked(key, name, o, tags)
 	scope.histogramsLock.Unlock()
 	return h
 }
 
-func (scope *Scope) histogramLocked(name string) *Histogram {
+func (scope *Scope) histogramLocked(key, name string, o HistogramOptions, tags []*Tag) *Histogram {
 	hs := scope.loadHistograms()
-	if h, ok := hs[name]; ok {
+	if h, ok := hs[key]; ok {
 		return h
 	}
 
+	if scope.rejects(name) {
+		return nullHistogram
+	}
+	if !scope.store.acquireMetric(scope, key) {
+		scope.overflowed(kindHistogram, key)
+		if scope.quota != scope {
+			return scope.quota.Histogram(overflowMetricName)
+		}
+		return scope.histogramLocked(overflowMetricName, overflowMetricName, HistogramOptions{}, nil)
+	}
+
 	tmp := make(map[string]*Histogram, len(hs))
-	for name, h := range hs {
-		tmp[name] = h
+	for key, h := range hs {
+		tmp[key] = h
 	}
-	finalName := scope.prefix + name
-	extractedName, tags := scope.store.getTagsForName(finalName)
-	h := NewHistogram(scope.store, finalName, extractedName, tags)
-	tmp[name] = h
+	h := newHistogram(scope.store, scope.newMetric(name, tags), o)
+	h.revive = func() { scope.reviveHistogram(key, h) }
+	tmp[key] = h
 	scope.updateHistograms(tmp)
 	return h
 }
 
+// reviveHistogram registers the evicted histogram again like reviveCounter.
+func (scope *Scope) reviveHistogram(key string, h *Histogram) {
+	scope.histogramsLock.Lock()
+	defer scope.histogramsLock.Unlock()
+	if scope.deleted {
+		return
+	}
+
+	hs := scope.loadHistograms()
+	if old, ok := hs[key]; ok {
+		old.evict()
+	} else {
+		scope.store.reclaimMetric(scope, key)
+	}
+	tmp := make(map[string]*Histogram, len(hs)+1)
+	for key, h := range hs {
+		tmp[key] = h
+	}
+	tmp[key] = h
+	scope.updateHistograms(tmp)
+}
+
 // Counters returns all known counters within the scope namespace.
 func (scope *Scope) Counters() []*Counter {
 	cs := scope.loadCounters()
@@ -220,6 +708,30 @@ func (scope *Scope) Gauges() []*Gauge {
 	return ret
 }
 
+// FloatGauges returns all known float gauges within the scope namespace.
+func (scope *Scope) FloatGauges() []*FloatGauge {
+	gs := scope.loadFloatGauges()
+	ret := make([]*FloatGauge, 0, len(gs))
+	for _, g := range gs {
+		if g.IsUsed() {
+			ret = append(ret, g)
+		}
+	}
+	return ret
+}
+
+// IntGauges returns all known signed gauges within the scope namespace.
+func (scope *Scope) IntGauges() []*IntGauge {
+	gs := scope.loadIntGauges()
+	ret := make([]*IntGauge, 0, len(gs))
+	for _, g := range gs {
+		if g.IsUsed() {
+			ret = append(ret, g)
+		}
+	}
+	return ret
+}
+
 // Histograms returns all known histograms within the scope namespace.
 func (scope *Scope) Histograms() []*Histogram {
 	hs := scope.loadHistograms()
@@ -231,3 +743,143 @@ func (scope *Scope) Histograms() []*Histogram {
 	}
 	return ret
 }
+
+// RemoveGauge removes the gauge with the given name and tags from the scope.
+func (scope *Scope) RemoveGauge(name string, tags ...*Tag) {
+	_, key := metricKey(name, tags)
+	scope.gaugesLock.Lock()
+	defer scope.gaugesLock.Unlock()
+
+	gs := scope.loadGauges()
+	if _, ok := gs[key]; !ok {
+		return
+	}
+	tmp := make(map[string]*Gauge, len(gs))
+	for key, g := range gs {
+		tmp[key] = g
+	}
+	delete(tmp, key)
+	scope.updateGauges(tmp)
+	scope.store.releaseMetric(scope, key)
+}
+
+// RemoveFloatGauge removes the float gauge with the given name from the scope.
+func (scope *Scope) RemoveFloatGauge(name string) {
+	scope.floatGaugesLock.Lock()
+	defer scope.floatGaugesLock.Unlock()
+
+	gs := scope.loadFloatGauges()
+	if _, ok := gs[name]; !ok {
+		return
+	}
+	tmp := make(map[string]*FloatGauge, len(gs))
+	for name, g := range gs {
+		tmp[name] = g
+	}
+	delete(tmp, name)
+	scope.updateFloatGauges(tmp)
+	scope.store.releaseMetric(scope, name)
+}
+
+// RemoveIntGauge removes the signed gauge with the given name from the scope.
+func (scope *Scope) RemoveIntGauge(name string) {
+	scope.intGaugesLock.Lock()
+	defer scope.intGaugesLock.Unlock()
+
+	gs := scope.loadIntGauges()
+	if _, ok := gs[name]; !ok {
+		return
+	}
+	tmp := make(map[string]*IntGauge, len(gs))
+	for name, g := range gs {
+		tmp[name] = g
+	}
+	delete(tmp, name)
+	scope.updateIntGauges(tmp)
+	scope.store.releaseMetric(scope, name)
+}
+
+// RemoveCounter removes the counter with the given name and tags from the scope.
+func (scope *Scope) RemoveCounter(name string, tags ...*Tag) {
+	_, key := metricKey(name, tags)
+	scope.countersLock.Lock()
+	defer scope.countersLock.Unlock()
+
+	cs := scope.loadCounters()
+	if _, ok := cs[key]; !ok {
+		return
+	}
+	tmp := make(map[string]*Counter, len(cs))
+	for key, c := range cs {
+		tmp[key] = c
+	}
+	delete(tmp, key)
+	scope.updateCounters(tmp)
+	scope.store.releaseMetric(scope, key)
+}
+
+// RemoveHistogram removes the histogram with the given name and tags from the scope.
+func (scope *Scope) RemoveHistogram(name string, tags ...*Tag) {
+	_, key := metricKey(name, tags)
+	scope.histogramsLock.Lock()
+	defer scope.histogramsLock.Unlock()
+
+	hs := scope.loadHistograms()
+	if _, ok := hs[key]; !ok {
+		return
+	}
+	tmp := make(map[string]*Histogram, len(hs))
+	for key, h := range hs {
+		tmp[key] = h
+	}
+	delete(tmp, key)
+	scope.updateHistograms(tmp)
+	scope.store.releaseMetric(scope, key)
+}
+
+// markDeleted stops reviving the evicted metrics of the deleted scope.
+func (scope *Scope) markDeleted() {
+	scope.countersLock.Lock()
+	scope.histogramsLock.Lock()
+	scope.deleted = true
+	scope.histogramsLock.Unlock()
+	scope.countersLock.Unlock()
+}
+
+// evictIdleMetrics removes the counters and histograms which haven't been
+// touched for the given number of consecutive checks. The evicted metrics are
+// registered again on the next update, so the handles held by callers keep
+// working. Gauges never expire, as they hold the current values.
+// NOTE: It must not be called concurrently.
+func (scope *Scope) evictIdleMetrics(maxChecks uint32) {
+	scope.countersLock.Lock()
+	cs := scope.loadCounters()
+	tmpCounters := make(map[string]*Counter, len(cs))
+	for key, c := range cs {
+		// the revival waits for the lock, so it never misses the eviction
+		if !c.checkIdle(maxChecks) || !c.evict() {
+			tmpCounters[key] = c
+		} else {
+			scope.store.releaseMetric(scope, key)
+		}
+	}
+	if len(tmpCounters) != len(cs) {
+		scope.updateCounters(tmpCounters)
+	}
+	scope.countersLock.Unlock()
+
+	scope.histogramsLock.Lock()
+	hs := scope.loadHistograms()
+	tmpHistograms := make(map[string]*Histogram, len(hs))
+	for key, h := range hs {
+		if !h.checkIdle(maxChecks) || !h.evict() {
+			tmpHistograms[key] = h
+		} else {
+			scope.store.releaseMetric(scope, key)
+		}
+	}
+	if len(tmpHistograms) != len(hs) {
+		scope.updateHistograms(tmpHistograms)
+	}
+	scope.histogramsLock.Unlock()
+}
@@ -39,6 +39,47 @@ func TestScopeObtainGauge(t *testing.T) {
 	assert.Equal(t, len(scope.Gauges()), 1)
 }
 
+func TestScopeObtainGaugeFunc(t *testing.T) {
+	scope := newScope("pool.", new(Store))
+	var size uint64
+	gauge := scope.GaugeFunc("size", func() uint64 { return size })
+	assert.Equal(t, gauge, scope.Gauge("size"))
+	assert.Equal(t, len(scope.Gauges()), 1)
+
+	size = 10
+	assert.Equal(t, uint64(10), scope.Gauges()[0].Value())
+
+	fgauge := scope.FloatGaugeFunc("usage", func() float64 { return 0.1 })
+	assert.Equal(t, fgauge, scope.FloatGauge("usage"))
+	assert.Equal(t, 0.1, scope.FloatGauges()[0].Value())
+}
+
+func TestScopeObtainFloatGauge(t *testing.T) {
+	scope := newScope("listener.foo.", new(Store))
+	gauge := scope.FloatGauge("ratio")
+	gauge1 := scope.FloatGauge("ratio")
+
+	assert.Equal(t, gauge, gauge1)
+	assert.Equal(t, gauge.Name(), "listener.foo.ratio")
+	assert.Equal(t, len(scope.FloatGauges()), 0)
+
+	gauge.Set(0.5)
+	assert.Equal(t, len(scope.FloatGauges()), 1)
+}
+
+func TestScopeObtainIntGauge(t *testing.T) {
+	scope := newScope("listener.foo.", new(Store))
+	gauge := scope.IntGauge("delta")
+	gauge1 := scope.IntGauge("delta")
+
+	assert.Equal(t, gauge, gauge1)
+	assert.Equal(t, gauge.Name(), "listener.foo.delta")
+	assert.Equal(t, len(scope.IntGauges()), 0)
+
+	gauge.Dec()
+	assert.Equal(t, len(scope.IntGauges()), 1)
+}
+
 func TestScopeObtainHistogram(t *testing.T) {
 	store := NewStore(NewStoreOption().WithFlushInterval(time.Minute))
 	scope := newScope("listener.foo.", store)
@@ -52,6 +93,117 @@ func TestScopeObtainHistogram(t *testing.T) {
 	assert.Equal(t, len(scope.Histograms()), 1)
 }
 
+func TestScopeObtainHistogramWithOptions(t *testing.T) {
+	store := NewStore(NewStoreOption().WithFlushInterval(time.Minute))
+	scope := newScope("queue.", store)
+	o := HistogramOptions{
+		Buckets:   []float64{100, 10, 1000},
+		Quantiles: []float64{0.999, 0.5},
+		Unit:      UnitBytes,
+	}
+	h := scope.HistogramWithOptions("size", o)
+	assert.Equal(t, h, scope.Histogram("size"))
+	assert.Equal(t, UnitBytes, h.Unit())
+
+	// the options are sorted and copied
+	o.Buckets[0] = 1
+	h.Record(50)
+	h.RefreshIntervalStatistics()
+	s := h.CumulativeStatistics()
+	assert.Equal(t, []float64{10, 100, 1000}, s.SupportedBuckets())
+	assert.Equal(t, []uint64{0, 1, 1}, s.ComputedBuckets())
+	assert.Equal(t, []float64{0.5, 0.999}, s.SupportedQuantiles())
+	assert.Contains(t, h.Summary(), "P50(")
+	assert.Contains(t, h.Summary(), "P99.9(")
+
+	// the defaults
+	s = scope.Histogram("latency").CumulativeStatistics()
+	assert.Equal(t, defaultSupportedBuckets, s.SupportedBuckets())
+	assert.Equal(t, defaultSupportedQuantiles, s.SupportedQuantiles())
+}
+
+func TestScopeObtainMetricsWithTags(t *testing.T) {
+	scope := newScope("upstream.", NewStore(nil))
+	c1 := scope.CounterWithTags("rq_total", &Tag{Name: "code", Value: "200"}, &Tag{Name: "cluster", Value: "foo"})
+	c2 := scope.CounterWithTags("rq_total", &Tag{Name: "cluster", Value: "foo"}, &Tag{Name: "code", Value: "200"})
+	c3 := scope.CounterWithTags("rq_total", &Tag{Name: "cluster", Value: "bar"}, &Tag{Name: "code", Value: "200"})
+	c4 := scope.Counter("rq_total")
+	assert.True(t, c1 == c2)
+	assert.False(t, c1 == c3)
+	assert.False(t, c1 == c4)
+
+	assert.Equal(t, "upstream.rq_total", c1.Name())
+	assert.Equal(t, "upstream.rq_total", c1.TagExtractedName())
+	assert.Equal(t, "upstream.rq_total{cluster=foo,code=200}", c1.Key())
+	assert.Equal(t, []*Tag{{Name: "cluster", Value: "foo"}, {Name: "code", Value: "200"}}, c1.Tags())
+	assert.Equal(t, "upstream.rq_total", c4.Key())
+
+	g := scope.GaugeWithTags("cx_active", &Tag{Name: "cluster", Value: "foo"})
+	assert.Equal(t, g, scope.GaugeWithTags("cx_active", &Tag{Name: "cluster", Value: "foo"}))
+	h := scope.HistogramWithTags("rq_time", &Tag{Name: "cluster", Value: "foo"})
+	assert.Equal(t, h, scope.HistogramWithTags("rq_time", &Tag{Name: "cluster", Value: "foo"}))
+	assert.Equal(t, "upstream.rq_time{cluster=foo}", h.Key())
+}
+
+func TestScopeTagged(t *testing.T) {
+	store := NewStore(nil)
+	store.SetTagOption(NewTagOption().WithDefaultTags(map[string]string{"zone": "hz"}))
+	scope := store.CreateScope("upstream")
+	assert.True(t, scope == scope.Tagged(nil))
+
+	tagged := scope.Tagged(map[string]string{"cluster": "foo"})
+	assert.True(t, tagged == scope.Tagged(map[string]string{"cluster": "foo"}))
+	assert.Equal(t, "upstream.", tagged.Name())
+	assert.Equal(t, []*Tag{{Name: "cluster", Value: "foo"}}, tagged.Tags())
+	assert.Equal(t, 2, len(store.Scopes()))
+
+	c := tagged.CounterWithTags("rq_total", &Tag{Name: "code", Value: "200"})
+	assert.Equal(t, "upstream.rq_total{cluster=foo,code=200}", c.Key())
+	assert.Equal(t, []*Tag{
+		{Name: "zone", Value: "hz"},
+		{Name: "cluster", Value: "foo"},
+		{Name: "code", Value: "200"},
+	}, c.Tags())
+
+	// explicit tags take precedence over the ones of scope
+	c = tagged.CounterWithTags("rq_total", &Tag{Name: "cluster", Value: "bar"})
+	assert.Equal(t, "upstream.rq_total{cluster=bar}", c.Key())
+
+	// children inherit the tags
+	child := tagged.NewChild("retry")
+	assert.Equal(t, "upstream.retry.", child.Name())
+	assert.Equal(t, tagged.Tags(), child.Tags())
+	assert.Equal(t, "upstream.retry.total{cluster=foo}", child.Counter("total").Key())
+
+	nested := tagged.Tagged(map[string]string{"code": "200"})
+	assert.Equal(t, []*Tag{{Name: "cluster", Value: "foo"}, {Name: "code", Value: "200"}}, nested.Tags())
+
+	store.DeleteScope(scope)
+	assert.Equal(t, 0, len(store.Scopes()))
+}
+
+func TestScopeDescribe(t *testing.T) {
+	store := NewStore(nil)
+	store.SetTagOption(NewTagOption().WithTagExtractStrategies(
+		TagExtractStrategy{Name: "cluster", Regex: "^upstream\\.((.*?)\\.)"},
+	))
+	scope := store.CreateScope("upstream")
+	defer store.DeleteScope(scope)
+
+	_, ok := store.Description("upstream.rq_total")
+	assert.False(t, ok)
+
+	scope.Describe("foo.rq_total", "total requests", UnitNone)
+	desc, ok := store.Description("upstream.rq_total")
+	assert.True(t, ok)
+	assert.Equal(t, Description{Help: "total requests"}, desc)
+
+	// the later one overrides the former
+	scope.Describe("bar.rq_total", "requests", UnitBytes)
+	desc, _ = store.Description("upstream.rq_total")
+	assert.Equal(t, Description{Help: "requests", Unit: UnitBytes}, desc)
+}
+
 func TestNewScopeWithChildren(t *testing.T) {
 	store := NewStore(NewStoreOption().WithFlushInterval(time.Minute))
 	scope := newScope("I.am.a.father.", store)
@@ -70,3 +222,94 @@ func TestNewScopeWithChildren(t *testing.T) {
 	children = scope.loadChildren()
 	assert.Equal(t, 2, len(children))
 }
+
+func TestScopeRemoveMetrics(t *testing.T) {
+	scope := newScope("upstream.", NewStore(nil))
+	c := scope.Counter("rq_total")
+	tc := scope.CounterWithTags("rq_total", &Tag{Name: "cluster", Value: "foo"})
+	scope.Gauge("cx_active")
+	scope.FloatGauge("load")
+	scope.IntGauge("delta")
+	scope.Histogram("rq_time")
+
+	scope.RemoveCounter("rq_total", &Tag{Name: "cluster", Value: "foo"})
+	assert.Equal(t, 1, len(scope.loadCounters()))
+	assert.True(t, c == scope.Counter("rq_total"))
+	assert.False(t, tc == scope.CounterWithTags("rq_total", &Tag{Name: "cluster", Value: "foo"}))
+
+	scope.RemoveCounter("rq_total")
+	scope.RemoveGauge("cx_active")
+	scope.RemoveFloatGauge("load")
+	scope.RemoveIntGauge("delta")
+	scope.RemoveHistogram("rq_time")
+	scope.RemoveHistogram("not_exist")
+	assert.Equal(t, 1, len(scope.loadCounters()))
+	assert.Equal(t, 0, len(scope.loadGauges()))
+	assert.Equal(t, 0, len(scope.loadFloatGauges()))
+	assert.Equal(t, 0, len(scope.loadIntGauges()))
+	assert.Equal(t, 0, len(scope.loadHistograms()))
+}
+
+func TestScopeEvictIdleMetrics(t *testing.T) {
+	scope := newScope("client.", NewStore(nil))
+	active := scope.Counter("active")
+	idle := scope.Counter("idle")
+	h := scope.Histogram("unused")
+	scope.Gauge("set").Set(1)
+	active.Inc()
+	idle.Inc()
+
+	scope.evictIdleMetrics(2)
+	assert.Equal(t, 2, len(scope.loadCounters()))
+	assert.Equal(t, 1, len(scope.loadHistograms()))
+
+	active.Inc()
+	scope.evictIdleMetrics(2)
+	assert.Equal(t, 2, len(scope.loadCounters()))
+	assert.Equal(t, 0, len(scope.loadHistograms()))
+
+	active.Inc()
+	scope.evictIdleMetrics(2)
+	cs := scope.loadCounters()
+	assert.Equal(t, 1, len(cs))
+	assert.True(t, active == cs["active"])
+	// the gauges never expire
+	assert.Equal(t, 1, len(scope.loadGauges()))
+
+	// the evicted ones are revived on update
+	idle.Inc()
+	h.Record(1)
+	assert.True(t, idle == scope.loadCounters()["idle"])
+	assert.True(t, h == scope.loadHistograms()["unused"])
+	assert.Equal(t, uint64(2), idle.Value())
+
+	// the one created again meanwhile is taken over
+	scope.evictIdleMetrics(2)
+	scope.evictIdleMetrics(2)
+	scope.evictIdleMetrics(2)
+	assert.Equal(t, 0, len(scope.loadCounters()))
+	again := scope.Counter("idle")
+	assert.False(t, again == idle)
+	idle.Inc()
+	assert.True(t, idle == scope.loadCounters()["idle"])
+	assert.Equal(t, uint64(2), scope.numMetrics())
+
+	// the handles of deleted scope aren't revived
+	scope.evictIdleMetrics(2)
+	scope.evictIdleMetrics(2)
+	scope.evictIdleMetrics(2)
+	scope.markDeleted()
+	idle.Inc()
+	assert.Equal(t, 0, len(scope.loadCounters()))
+}
+
+func TestScopeHistogramWithInvalidQuantiles(t *testing.T) {
+	store := NewStore(nil)
+	scope := store.CreateScope("rq")
+	assert.Panics(t, func() {
+		scope.HistogramWithOptions("time", HistogramOptions{Quantiles: []float64{1.5}})
+	})
+	// the scope is still usable
+	h := scope.HistogramWithOptions("time", HistogramOptions{Quantiles: []float64{0.5}})
+	assert.Equal(t, []float64{0.5}, h.quantiles)
+}
@@ -1,44 +1,83 @@
 package stats
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 // MetricsSnapshot represents the metrics snapshot in a particular time.
 type MetricsSnapshot interface {
 	// Gauges returns all known guages.
 	Gauges() []*Gauge
+	// FloatGauges returns all known float gauges.
+	FloatGauges() []*FloatGauge
+	// IntGauges returns all known signed gauges.
+	IntGauges() []*IntGauge
 	// Counters returns all known counters.
 	Counters() []*Counter
 	// Histograms returns all known histograms.
 	Histograms() []*Histogram
 }
 
-var _ MetricsSnapshot = new(metricsSnapshot)
+var (
+	_ MetricsSnapshot = new(metricsSnapshot)
+	_ MetricsSnapshot = new(Store)
+	_ Describer       = new(metricsSnapshot)
+)
 
 type metricsSnapshot struct {
-	gauges     []*Gauge
-	counters   []*Counter
-	histograms []*Histogram
+	gauges      []*Gauge
+	floatGauges []*FloatGauge
+	intGauges   []*IntGauge
+	counters    []*Counter
+	histograms  []*Histogram
+	describer   Describer // the descriptions of metrics, e.g. the store
 }
 
-func newMetricsSnapshot(gauges []*Gauge, counters []*Counter, histograms []*Histogram) *metricsSnapshot {
+func newMetricsSnapshot(gauges []*Gauge, floatGauges []*FloatGauge, intGauges []*IntGauge,
+	counters []*Counter, histograms []*Histogram) *metricsSnapshot {
 	snap := &metricsSnapshot{
-		gauges:     gauges,
-		counters:   counters,
-		histograms: histograms,
+		gauges:      gauges,
+		floatGauges: floatGauges,
+		intGauges:   intGauges,
+		counters:    counters,
+		histograms:  histograms,
 	}
+	refreshIntervals(snap.counters, snap.histograms)
+	return snap
+}
+
+// refreshIntervals refreshes the interval values presented by the metrics
+// themselves, e.g. Counter.IntervalValue and Histogram.IntervalStatistics.
+func refreshIntervals(counters []*Counter, histograms []*Histogram) {
 	// refresh counter interval value.
-	for _, counter := range snap.counters {
+	for _, counter := range counters {
 		counter.Latch()
 	}
 	// refresh histogram interval stattistic.
-	for _, histogram := range snap.histograms {
+	for _, histogram := range histograms {
 		histogram.RefreshIntervalStatistics()
 	}
-	return snap
 }
 
 func (snap *metricsSnapshot) Gauges() []*Gauge {
 	return snap.gauges
 }
 
+func (snap *metricsSnapshot) FloatGauges() []*FloatGauge {
+	return snap.floatGauges
+}
+
+func (snap *metricsSnapshot) IntGauges() []*IntGauge {
+	return snap.intGauges
+}
+
 func (snap *metricsSnapshot) Counters() []*Counter {
 	return snap.counters
 }
@@ -47,6 +86,446 @@ func (snap *metricsSnapshot) Histograms() []*Histogram {
 	return snap.histograms
 }
 
+// Description returns the description of the metrics from the describer
+// of snapshot, so that the sinks are able to present it, e.g. as HELP.
+func (snap *metricsSnapshot) Description(name string) (Description, bool) {
+	if snap.describer == nil {
+		return Description{}, false
+	}
+	return snap.describer.Description(name)
+}
+
+var (
+	// ErrFlushTimeout is reported when a flush of sink exceeds its timeout.
+	ErrFlushTimeout = errors.New("flush timed out")
+	// ErrFlushInProgress is reported when a flush of sink is skipped since
+	// the previous one hasn't returned yet, e.g. it timed out.
+	ErrFlushInProgress = errors.New("previous flush is still in progress")
+	// ErrSinkClosed is reported when a flush of sink is skipped since the
+	// sink has been closed by Store.Close.
+	ErrSinkClosed = errors.New("sink is closed")
+)
+
+// FlushError is the error occurred during flushing a sink.
+type FlushError struct {
+	Sink string    // the name of sink
+	Err  error     // the underlying error
+	Time time.Time // when the error occurred
+}
+
+func newFlushError(sink string, err error) *FlushError {
+	return &FlushError{Sink: sink, Err: err, Time: time.Now()}
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf("flush sink %s: %v", e.Sink, e.Err)
+}
+
+// Unwrap returns the underlying error, which makes errors.Is match it, e.g.
+// ErrFlushTimeout.
+func (e *FlushError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the underlying error like Unwrap, which is used by
+// github.com/pkg/errors.Cause.
+func (e *FlushError) Cause() error {
+	return e.Err
+}
+
+// sinkEntry is a registered sink with its own flush cadence.
+type sinkEntry struct {
+	sink     Sink
+	name     string
+	interval time.Duration // zero means the flush interval of store
+	timeout  time.Duration // zero means no timeout
+
+	mu        sync.Mutex
+	closed    int32         // no more flushes or samples once closed
+	flushDone chan struct{} // closed once the in-flight flush returns, nil if none
+	latch     *latchState
+
+	samples      chan histogramSample // nil if the sink discards samples
+	dropPolicy   DropPolicy
+	workerOnce   sync.Once
+	workerActive int32
+	workerStop   chan struct{} // closed once the entry closed
+	workerDone   chan struct{}
+	droppedOnce  sync.Once
+	dropped      *Counter // stats.sink.<name>.samples_dropped
+}
+
+// histogramSample is a raw sample queued to be written to sink.
+type histogramSample struct {
+	h       *Histogram
+	val     uint64
+	fval    float64
+	isFloat bool // whether the sample is recorded as float
+}
+
+// newSinkEntry creates the entry of sink registered after the given entries,
+// the default name is suffixed with the index of sink if it's taken.
+func newSinkEntry(sink Sink, o *SinkOption, entries []*sinkEntry) *sinkEntry {
+	if o == nil {
+		o = NewSinkOption()
+	}
+	name := o.Name
+	if name == "" {
+		index := len(entries)
+		if ns, ok := sink.(NamedSink); ok {
+			name = ns.Name()
+		} else {
+			name = fmt.Sprintf("sink%d", index)
+		}
+		for _, entry := range entries {
+			if entry.name == name {
+				name = fmt.Sprintf("%s%d", name, index)
+				break
+			}
+		}
+	}
+	entry := &sinkEntry{
+		sink:       sink,
+		name:       name,
+		interval:   o.FlushInterval,
+		timeout:    o.FlushTimeout,
+		latch:      newLatchState(),
+		dropPolicy: o.SampleDropPolicy,
+		workerStop: make(chan struct{}),
+		workerDone: make(chan struct{}),
+	}
+	if ds, ok := sink.(SampleDiscardingSink); !ok || !ds.DiscardsHistogramSamples() {
+		queueSize := o.SampleQueueSize
+		if queueSize <= 0 {
+			queueSize = defaultSampleQueueSize
+		}
+		entry.samples = make(chan histogramSample, queueSize)
+	}
+	return entry
+}
+
+// writeSample queues the histogram sample, which is written to the sink by
+// a worker started on the first sample. The dropped samples are counted by
+// stats.sink.<name>.samples_dropped. The samples are ignored if the sink
+// discards them or it's closed.
+func (entry *sinkEntry) writeSample(store *Store, sample histogramSample) {
+	if entry.samples == nil || atomic.LoadInt32(&entry.closed) == 1 {
+		return
+	}
+	entry.workerOnce.Do(func() {
+		atomic.StoreInt32(&entry.workerActive, 1)
+		go entry.sampleWorker()
+	})
+	if !entry.enqueueSample(sample) {
+		entry.countDropped(store, 1)
+	}
+}
+
+// countDropped counts the dropped samples, the counter is resolved once as
+// it's revived on update even if evicted.
+func (entry *sinkEntry) countDropped(store *Store, n uint64) {
+	entry.droppedOnce.Do(func() {
+		entry.dropped = store.getStatsScope().Counter(entry.statsName("samples_dropped"))
+	})
+	entry.dropped.Add(n)
+}
+
+// enqueueSample puts the sample into the queue without blocking, it
+// returns false if a sample was dropped.
+func (entry *sinkEntry) enqueueSample(sample histogramSample) bool {
+	select {
+	case entry.samples <- sample:
+		return true
+	default:
+	}
+	if entry.dropPolicy == DropNewest {
+		return false
+	}
+
+	// drop the oldest one to make room
+	select {
+	case <-entry.samples:
+	default:
+	}
+	select {
+	case entry.samples <- sample:
+	default:
+	}
+	return false
+}
+
+// sampleWorker writes the queued samples to sink until the entry closed,
+// the samples remained in the queue are written before it exits. So the
+// store must be closed to release the worker.
+func (entry *sinkEntry) sampleWorker() {
+	defer close(entry.workerDone)
+	for {
+		select {
+		case sample := <-entry.samples:
+			entry.write(sample)
+		case <-entry.workerStop:
+			for {
+				select {
+				case sample := <-entry.samples:
+					entry.write(sample)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes the sample to sink, the float samples are rounded for the
+// sinks which don't accept them.
+func (entry *sinkEntry) write(sample histogramSample) {
+	if !sample.isFloat {
+		entry.sink.WriteHistogramSample(sample.h, sample.val) //nolint:errcheck
+		return
+	}
+	if fs, ok := entry.sink.(FloatSampleSink); ok {
+		fs.WriteHistogramFloatSample(sample.h, sample.fval) //nolint:errcheck
+		return
+	}
+	entry.sink.WriteHistogramSample(sample.h, uint64(math.Round(math.Max(sample.fval, 0)))) //nolint:errcheck
+}
+
+// beginFlush marks a flush of the sink in progress, the returned chan must
+// be closed once the flush returns. It fails if the sink is closed, or the
+// previous flush hasn't returned. If wait is true, it waits for the previous
+// flush until ctx done instead.
+func (entry *sinkEntry) beginFlush(ctx context.Context, wait bool) (chan struct{}, error) {
+	for {
+		entry.mu.Lock()
+		if atomic.LoadInt32(&entry.closed) == 1 {
+			entry.mu.Unlock()
+			return nil, ErrSinkClosed
+		}
+		if !entry.inFlightLocked() {
+			entry.flushDone = make(chan struct{})
+			done := entry.flushDone
+			entry.mu.Unlock()
+			return done, nil
+		}
+		inFlight := entry.flushDone
+		entry.mu.Unlock()
+
+		if !wait {
+			return nil, ErrFlushInProgress
+		}
+		select {
+		case <-inFlight:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (entry *sinkEntry) inFlightLocked() bool {
+	if entry.flushDone == nil {
+		return false
+	}
+	select {
+	case <-entry.flushDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// close closes the sink if it implements io.Closer, no more flushes or
+// samples are accepted after that. The sink is closed once the in-flight
+// flush and the queued samples are done, it returns ctx.Err() if ctx is done
+// before, and the sink is closed in background then. The samples racing with
+// it are counted as dropped.
+func (entry *sinkEntry) close(ctx context.Context, store *Store, histograms []*Histogram) error {
+	entry.mu.Lock()
+	atomic.StoreInt32(&entry.closed, 1)
+	flushDone := entry.flushDone
+	entry.mu.Unlock()
+	// no more sample worker is started, and the started one stops after the
+	// entry closed, so that no sample is left behind by it.
+	entry.workerOnce.Do(func() {})
+	close(entry.workerStop)
+
+	idle := make(chan struct{})
+	go func() {
+		if flushDone != nil {
+			<-flushDone
+		}
+		// no more snapshots are taken by the latch
+		entry.latch.release(histograms)
+		if atomic.LoadInt32(&entry.workerActive) == 1 {
+			<-entry.workerDone
+		}
+		if n := len(entry.samples); n > 0 {
+			entry.countDropped(store, uint64(n))
+		}
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return entry.closeSink()
+	case <-ctx.Done():
+		go func() {
+			<-idle
+			entry.closeSink() //nolint:errcheck
+		}()
+		return ctx.Err()
+	}
+}
+
+func (entry *sinkEntry) closeSink() error {
+	if closer, ok := entry.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// statsName returns the name of the self-metric of sink within the stats
+// scope. The ones updated per flush are looked up on every use, while
+// samples_dropped is kept by the entry as it's updated per sample.
+func (entry *sinkEntry) statsName(name string) string {
+	return "sink." + entry.name + "." + name
+}
+
+// flush flushes the snapshot to sink and records the self-metrics, it
+// returns once the flush done or timed out. The done chan is closed once the
+// flush returns, even if it timed out.
+func (entry *sinkEntry) flush(store *Store, snapshot MetricsSnapshot, done chan struct{}) error {
+	flushed := make(chan error, 1)
+	go func() {
+		defer close(done)
+
+		start := time.Now()
+		err := entry.sink.Flush(snapshot)
+		scope := store.getStatsScope()
+		scope.Timer(entry.statsName("flush_latency")).RecordDuration(time.Since(start))
+		if err != nil {
+			scope.Counter(entry.statsName("flush_errors")).Inc()
+		} else {
+			scope.Gauge(entry.statsName("last_success_timestamp")).Set(uint64(time.Now().Unix()))
+		}
+		flushed <- err
+	}()
+
+	if entry.timeout <= 0 {
+		return <-flushed
+	}
+	timer := time.NewTimer(entry.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-flushed:
+		return err
+	case <-timer.C:
+		store.getStatsScope().Counter(entry.statsName("flush_timeouts")).Inc()
+		return ErrFlushTimeout
+	}
+}
+
+// latchState records what a sink has seen so far, so that every sink
+// observes the interval values of its own cadence.
+type latchState struct {
+	counters map[*Counter]uint64 // the counter values of the last flush
+}
+
+func newLatchState() *latchState {
+	return &latchState{counters: make(map[*Counter]uint64)}
+}
+
+// release forgets what the latch has seen, including the pending intervals
+// kept by the histograms for it. The latch mustn't be used after that.
+func (l *latchState) release(histograms []*Histogram) {
+	for _, h := range histograms {
+		h.forgetConsumer(l)
+	}
+	l.counters = make(map[*Counter]uint64)
+}
+
+// prime makes the given counters start counting intervals from now on.
+func (l *latchState) prime(counters []*Counter) {
+	for _, counter := range counters {
+		l.counters[counter] = counter.Value()
+	}
+}
+
+// snapshot makes a read-only copy of the given metrics, the interval values
+// of which are calculated since the last snapshot of the latch.
+func (l *latchState) snapshot(gauges []*Gauge, floatGauges []*FloatGauge, intGauges []*IntGauge,
+	counters []*Counter, histograms []*Histogram) *metricsSnapshot {
+	snap := &metricsSnapshot{
+		gauges:      make([]*Gauge, 0, len(gauges)),
+		floatGauges: make([]*FloatGauge, 0, len(floatGauges)),
+		intGauges:   make([]*IntGauge, 0, len(intGauges)),
+		counters:    make([]*Counter, 0, len(counters)),
+		histograms:  make([]*Histogram, 0, len(histograms)),
+	}
+	for _, g := range gauges {
+		snap.gauges = append(snap.gauges, &Gauge{metric: g.clone(), val: g.Value()})
+	}
+	for _, g := range floatGauges {
+		snap.floatGauges = append(snap.floatGauges, &FloatGauge{metric: g.clone(), bits: math.Float64bits(g.Value())})
+	}
+	for _, g := range intGauges {
+		snap.intGauges = append(snap.intGauges, &IntGauge{metric: g.clone(), val: g.Value()})
+	}
+
+	// the removed counters are forgotten by rebuilding the last values.
+	last := make(map[*Counter]uint64, len(counters))
+	for _, c := range counters {
+		val := c.Value()
+		last[c] = val
+		snap.counters = append(snap.counters, &Counter{
+			metric:      c.clone(),
+			val:         val,
+			intervalVal: val - l.counters[c],
+		})
+	}
+	l.counters = last
+
+	for _, h := range histograms {
+		snap.histograms = append(snap.histograms, &Histogram{
+			metric:    h.clone(),
+			quantiles: h.quantiles,
+			buckets:   h.buckets,
+			unit:      h.unit,
+			itl:       h.takeInterval(l),
+			cum:       h.cum,
+			window:    h.window,
+			slots:     h.windowSlots(),
+		})
+	}
+	return snap
+}
+
+// NamedSink is a Sink with a name, which identifies the sink in the
+// self-metrics of the store.
+type NamedSink interface {
+	Sink
+	// Name returns the name of sink.
+	Name() string
+}
+
+// FloatSampleSink is a Sink accepting the float histogram samples, e.g.
+// the ones recorded by RecordFloat and RecordScaled. The float samples are
+// rounded for the sinks not implementing it.
+type FloatSampleSink interface {
+	Sink
+	// WriteHistogramFloatSample writes a single float histogram sample to
+	// the backing store directly.
+	WriteHistogramFloatSample(h *Histogram, val float64) error
+}
+
+// SampleDiscardingSink is a Sink which may discard the histogram samples,
+// e.g. the one exposing the histograms aggregated by Flush only. No sample is
+// queued for it if it discards them, so no worker is started for it.
+type SampleDiscardingSink interface {
+	Sink
+	// DiscardsHistogramSamples reports whether the sink discards the samples
+	// written by WriteHistogramSample.
+	DiscardsHistogramSamples() bool
+}
+
 // Sink is a sink for stats. Each Sink is responsible for writing stats
 // to a backing store.
 type Sink interface {
@@ -0,0 +1,136 @@
+package pushgateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kirk91/stats"
+	stathttp "github.com/kirk91/stats/http"
+)
+
+var (
+	_ stats.NamedSink            = new(sink)
+	_ stats.SampleDiscardingSink = new(sink)
+)
+
+type sink struct {
+	url       string
+	method    string
+	client    *http.Client
+	formatter *stathttp.PrometheusFormatter
+}
+
+// New returns a new sink which pushes the metrics to the pushgateway at the
+// address, e.g. http://127.0.0.1:9091, grouped by the job and the grouping
+// keys of option. nil option means the default one.
+// Refer to https://github.com/prometheus/pushgateway#url
+func New(gateway, job string, o *Option) *sink {
+	if o == nil {
+		o = NewOption()
+	}
+	method := o.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultPushTimeout}
+	}
+	return &sink{
+		url:       groupingURL(gateway, job, o),
+		method:    method,
+		client:    client,
+		formatter: stathttp.NewPrometheusFormatter(o.Namespace, o.PrometheusOption),
+	}
+}
+
+// groupingURL returns the URL of the group, the labels of which are ordered
+// by job, instance and the sorted names of others.
+func groupingURL(gateway, job string, o *Option) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(gateway, "/"))
+	b.WriteString("/metrics")
+	writeLabel := func(name, value string) {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(name))
+		// the value containing slash or being empty must be encoded by base64
+		if value == "" || strings.Contains(value, "/") {
+			b.WriteString("@base64/")
+			b.WriteString(base64.URLEncoding.EncodeToString([]byte(value)))
+			if value == "" {
+				b.WriteByte('=')
+			}
+			return
+		}
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(value))
+	}
+
+	writeLabel("job", job)
+	if o.Instance != "" {
+		writeLabel("instance", o.Instance)
+	}
+	names := make([]string, 0, len(o.GroupingKeys))
+	for name := range o.GroupingKeys {
+		if name == "job" || name == "instance" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeLabel(name, o.GroupingKeys[name])
+	}
+	return b.String()
+}
+
+// Name returns the name of sink.
+func (s *sink) Name() string {
+	return "pushgateway"
+}
+
+// Flush pushes the metrics of snapshot to the pushgateway.
+func (s *sink) Flush(snapshot stats.MetricsSnapshot) error {
+	buf := new(bytes.Buffer)
+	if err := s.formatter.FormatTo(buf, snapshot); err != nil {
+		return errors.Wrap(err, "error formatting metrics")
+	}
+	req, err := http.NewRequest(s.method, s.url, buf)
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Type", s.formatter.ContentType())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error pushing metrics")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("unexpected status %d pushing metrics to %s: %s",
+			resp.StatusCode, s.url, bytes.TrimSpace(body))
+	}
+	io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+	return nil
+}
+
+// WriteHistogramSample is a no-op, as the histograms are pushed with the
+// snapshot.
+func (s *sink) WriteHistogramSample(h *stats.Histogram, val uint64) error {
+	return nil
+}
+
+// DiscardsHistogramSamples reports the samples are discarded, so that they
+// aren't queued for the sink.
+func (s *sink) DiscardsHistogramSamples() bool {
+	return true
+}
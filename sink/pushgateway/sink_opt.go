@@ -0,0 +1,81 @@
+package pushgateway
+
+import (
+	"net/http"
+	"time"
+
+	stathttp "github.com/kirk91/stats/http"
+)
+
+// Option contains options of the pushgateway sink like the grouping keys,
+// the method of pushing, etc.
+type Option struct {
+	// Instance is the instance label of the grouping key, empty means the
+	// metrics aren't grouped by instance.
+	Instance string
+	// GroupingKeys are the extra labels of the grouping key besides job and
+	// instance, which are ignored here.
+	GroupingKeys map[string]string
+	// Method is the HTTP method of pushing, PUT replaces all the metrics of
+	// the group, while POST only replaces the ones of the same names.
+	Method string
+	// Namespace is the prefix of metric names like PrometheusHandler.
+	Namespace string
+	// PrometheusOption is the option to format the metrics, nil means the
+	// default one.
+	PrometheusOption *stathttp.PrometheusOption
+	// Client is the HTTP client to push the metrics.
+	Client *http.Client
+}
+
+const defaultPushTimeout = time.Second * 10
+
+// NewOption creates an Option which pushes the metrics by PUT with a 10s
+// timeout.
+func NewOption() *Option {
+	return &Option{
+		Method: http.MethodPut,
+		Client: &http.Client{Timeout: defaultPushTimeout},
+	}
+}
+
+// WithInstance returns an Option that sets the instance of grouping key.
+func (opt *Option) WithInstance(instance string) *Option {
+	opt.Instance = instance
+	return opt
+}
+
+// WithGroupingKey returns an Option that adds a label to the grouping key.
+func (opt *Option) WithGroupingKey(name, value string) *Option {
+	if opt.GroupingKeys == nil {
+		opt.GroupingKeys = make(map[string]string)
+	}
+	opt.GroupingKeys[name] = value
+	return opt
+}
+
+// WithMethod returns an Option that sets the HTTP method of pushing, either
+// PUT or POST.
+func (opt *Option) WithMethod(method string) *Option {
+	opt.Method = method
+	return opt
+}
+
+// WithNamespace returns an Option that sets the namespace of metric names.
+func (opt *Option) WithNamespace(namespace string) *Option {
+	opt.Namespace = namespace
+	return opt
+}
+
+// WithPrometheusOption returns an Option that sets the option to format
+// the metrics.
+func (opt *Option) WithPrometheusOption(o *stathttp.PrometheusOption) *Option {
+	opt.PrometheusOption = o
+	return opt
+}
+
+// WithClient returns an Option that sets the HTTP client to push the metrics.
+func (opt *Option) WithClient(client *http.Client) *Option {
+	opt.Client = client
+	return opt
+}
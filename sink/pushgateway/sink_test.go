@@ -0,0 +1,119 @@
+package pushgateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kirk91/stats"
+)
+
+type pushRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+}
+
+func newPushgateway(t *testing.T, status int) (*httptest.Server, <-chan *pushRequest) {
+	reqs := make(chan *pushRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body failed: %v", err)
+		}
+		reqs <- &pushRequest{
+			method:      r.Method,
+			path:        r.URL.EscapedPath(),
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		}
+		w.WriteHeader(status)
+	}))
+	return srv, reqs
+}
+
+func TestGroupingURL(t *testing.T) {
+	o := NewOption().WithInstance("10.0.0.1:80").
+		WithGroupingKey("path", "/var/tmp").
+		WithGroupingKey("env", "").
+		WithGroupingKey("job", "ignored")
+	assert.Equal(t,
+		"http://gw/metrics/job/batch/instance/10.0.0.1:80/env@base64/=/path@base64/L3Zhci90bXA=",
+		groupingURL("http://gw/", "batch", o))
+	assert.Equal(t, "http://gw/metrics/job/batch", groupingURL("http://gw", "batch", NewOption()))
+}
+
+type snapshot struct {
+	gauges   []*stats.Gauge
+	counters []*stats.Counter
+}
+
+func (s *snapshot) Gauges() []*stats.Gauge           { return s.gauges }
+func (s *snapshot) FloatGauges() []*stats.FloatGauge { return nil }
+func (s *snapshot) IntGauges() []*stats.IntGauge     { return nil }
+func (s *snapshot) Counters() []*stats.Counter       { return s.counters }
+func (s *snapshot) Histograms() []*stats.Histogram   { return nil }
+
+func TestFlush(t *testing.T) {
+	srv, reqs := newPushgateway(t, http.StatusOK)
+	defer srv.Close()
+
+	c := stats.NewCounter("rq.total.code.200", "rq.total", []*stats.Tag{{Name: "code", Value: "200"}})
+	c.Add(3)
+	g := stats.NewGauge("cx.active", "cx.active", nil)
+	g.Set(2)
+	snap := &snapshot{gauges: []*stats.Gauge{g}, counters: []*stats.Counter{c}}
+
+	s := New(srv.URL, "batch", NewOption().WithInstance("host1").WithNamespace("myapp"))
+	assert.Equal(t, "pushgateway", s.Name())
+	assert.NoError(t, s.Flush(snap))
+	req := <-reqs
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "/metrics/job/batch/instance/host1", req.path)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", req.contentType)
+	assert.Equal(t, "# TYPE myapp_cx_active gauge\nmyapp_cx_active{} 2\n"+
+		"# TYPE myapp_rq_total counter\nmyapp_rq_total{code=\"200\"} 3\n", req.body)
+
+	s = New(srv.URL, "batch", NewOption().WithMethod(http.MethodPost))
+	assert.NoError(t, s.Flush(snap))
+	req = <-reqs
+	assert.Equal(t, http.MethodPost, req.method)
+	assert.Equal(t, "/metrics/job/batch", req.path)
+}
+
+func TestFlushError(t *testing.T) {
+	srv, reqs := newPushgateway(t, http.StatusBadRequest)
+	defer srv.Close()
+
+	s := New(srv.URL, "batch", nil)
+	err := s.Flush(&snapshot{})
+	<-reqs
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected status 400")
+
+	srv.Close()
+	assert.Error(t, s.Flush(&snapshot{}))
+}
+
+func TestStoreFlush(t *testing.T) {
+	srv, reqs := newPushgateway(t, http.StatusOK)
+	defer srv.Close()
+
+	store := stats.NewStore(nil)
+	store.AddSink(New(srv.URL, "batch", NewOption().WithNamespace("myapp")))
+	scope := store.CreateScope("rq")
+	scope.Describe("total", "total requests", stats.UnitNone)
+	scope.Counter("total").Inc()
+
+	// the descriptions of store are pushed with the snapshot
+	store.Flush()
+	req := <-reqs
+	assert.Contains(t, req.body, "# HELP myapp_rq_total total requests\n")
+	assert.Contains(t, req.body, "myapp_rq_total{} 1\n")
+	assert.NoError(t, store.Close(context.Background()))
+}
@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"sync"
 	"time"
 
 	"github.com/kirk91/statsd"
@@ -9,23 +10,41 @@ import (
 	"github.com/kirk91/stats"
 )
 
-var _ stats.Sink = new(sink)
+var (
+	_ stats.NamedSink       = new(sink)
+	_ stats.FloatSampleSink = new(sink)
+)
+
+// clientFlushPeriod is the interval at which the client sends out the buffered metrics.
+const clientFlushPeriod = time.Millisecond * 100
 
 type sink struct {
 	address string
 	prefix  string
+
+	mu      sync.Mutex
 	_client *statsd.Client
 }
 
 // New returns a new sink for statsd.
+// NOTE: The underlying client can't be closed, it's created on the first
+// use and lives as long as the process, including its connection and the
+// ticker sending out the buffered metrics every 100ms. So the sink doesn't
+// implement io.Closer, the metrics buffered by the last flush are sent out
+// by the ticker shortly after Store.Close.
 func New(address string, prefix string) *sink {
 	return &sink{address: address, prefix: prefix}
 }
 
 func (s *sink) getClient() (*statsd.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	var err error
 	if s._client == nil {
-		s._client, err = statsd.New("udp", s.address, statsd.Prefix(s.prefix))
+		s._client, err = statsd.New("udp", s.address,
+			statsd.Prefix(s.prefix),
+			statsd.FlushPeriod(clientFlushPeriod))
 		if err != nil {
 			err = errors.Wrap(err, "error creating statsd client")
 		}
@@ -33,6 +52,11 @@ func (s *sink) getClient() (*statsd.Client, error) {
 	return s._client, err
 }
 
+// Name returns the name of sink.
+func (s *sink) Name() string {
+	return "statsd"
+}
+
 // Flush sends cached metrics from source to sink.
 func (s *sink) Flush(snapshot stats.MetricsSnapshot) error {
 	cli, err := s.getClient()
@@ -41,6 +65,8 @@ func (s *sink) Flush(snapshot stats.MetricsSnapshot) error {
 	}
 	s.flushCounters(cli, snapshot.Counters())
 	s.flushGauges(cli, snapshot.Gauges())
+	s.flushFloatGauges(cli, snapshot.FloatGauges())
+	s.flushIntGauges(cli, snapshot.IntGauges())
 	return nil
 }
 
@@ -57,11 +83,33 @@ func (s *sink) flushGauges(cli *statsd.Client, gs []*stats.Gauge) {
 	}
 }
 
+func (s *sink) flushFloatGauges(cli *statsd.Client, gs []*stats.FloatGauge) {
+	for _, g := range gs {
+		cli.GaugeFloat64fWithHost(g.Value(), g.Name())
+	}
+}
+
+func (s *sink) flushIntGauges(cli *statsd.Client, gs []*stats.IntGauge) {
+	for _, g := range gs {
+		cli.GaugeInt64fWithHost(g.Value(), g.Name())
+	}
+}
+
 func (s *sink) WriteHistogramSample(h *stats.Histogram, val uint64) error {
 	cli, err := s.getClient()
 	if err != nil {
 		return errors.Wrap(err, "error getting client")
 	}
-	cli.TimingfWithHost(time.Duration(val)*time.Millisecond, h.Name())
+	// the values without time unit are sent as milliseconds.
+	cli.TimingfWithHost(h.Unit().Duration(float64(val)), h.Name())
+	return nil
+}
+
+func (s *sink) WriteHistogramFloatSample(h *stats.Histogram, val float64) error {
+	cli, err := s.getClient()
+	if err != nil {
+		return errors.Wrap(err, "error getting client")
+	}
+	cli.TimingfWithHost(h.Unit().Duration(val), h.Name())
 	return nil
 }
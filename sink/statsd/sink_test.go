@@ -2,10 +2,12 @@ package statsd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,9 +16,10 @@ import (
 )
 
 type statsdServer struct {
-	l      net.PacketConn
-	closed bool
-	buf    bytes.Buffer
+	l net.PacketConn
+
+	mu  sync.Mutex
+	buf bytes.Buffer
 }
 
 func newStatsdServer(t *testing.T) *statsdServer {
@@ -30,15 +33,13 @@ func newStatsdServer(t *testing.T) *statsdServer {
 	go func() {
 		b := make([]byte, 1024)
 		for {
-			if s.closed {
-				return
-			}
 			n, _, err := l.ReadFrom(b)
-			if n == 0 {
-				continue
+			if err != nil {
+				return
 			}
-			assert.NoError(t, err)
+			s.mu.Lock()
 			s.buf.Write(b[:n])
+			s.mu.Unlock()
 		}
 	}()
 
@@ -50,16 +51,19 @@ func (s *statsdServer) Addr() string {
 }
 
 func (s *statsdServer) Close() {
-	s.closed = true
 	s.l.Close()
 }
 
 func (s *statsdServer) Reset() {
+	s.mu.Lock()
 	s.buf.Reset()
+	s.mu.Unlock()
 }
 
 func (s *statsdServer) Content() string {
-	return string(s.buf.Bytes())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
 }
 
 func getHostname() string {
@@ -106,6 +110,27 @@ func TestFlushGauges(t *testing.T) {
 	assert.Contains(t, ss.Content(), fmt.Sprintf("%s.%s.bar:1|g", prefix, getHostname()))
 }
 
+func TestFlushFloatAndIntGauges(t *testing.T) {
+	ss := newStatsdServer(t)
+	defer ss.Close()
+
+	prefix := "samaritan"
+	s := New(ss.Addr(), prefix)
+
+	fg := stats.NewFloatGauge("ratio", "", nil)
+	fg.Set(0.5)
+	ig := stats.NewIntGauge("delta", "", nil)
+	ig.Dec()
+
+	cli, err := s.getClient()
+	assert.NoError(t, err)
+	s.flushFloatGauges(cli, []*stats.FloatGauge{fg})
+	s.flushIntGauges(cli, []*stats.IntGauge{ig})
+	time.Sleep(time.Millisecond * 200)
+	assert.Contains(t, ss.Content(), fmt.Sprintf("%s.%s.ratio:0.5|g", prefix, getHostname()))
+	assert.Contains(t, ss.Content(), fmt.Sprintf("%s.%s.delta:-1|g", prefix, getHostname()))
+}
+
 func TestWriteHistogramSample(t *testing.T) {
 	ss := newStatsdServer(t)
 	defer ss.Close()
@@ -118,3 +143,44 @@ func TestWriteHistogramSample(t *testing.T) {
 	time.Sleep(time.Millisecond * 200)
 	assert.Equal(t, ss.Content(), fmt.Sprintf("%s.%s.haha:10|ms\n", prefix, getHostname()))
 }
+
+func TestWriteHistogramSampleWithUnit(t *testing.T) {
+	ss := newStatsdServer(t)
+	defer ss.Close()
+
+	prefix := "samaritan"
+	s := New(ss.Addr(), prefix)
+
+	store := stats.NewStore(nil)
+	h := store.CreateScope("").HistogramWithOptions("latency", stats.HistogramOptions{Unit: stats.UnitMicroseconds})
+	s.WriteHistogramSample(h, uint64(1500))
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, ss.Content(), fmt.Sprintf("%s.%s.latency:1.5|ms\n", prefix, getHostname()))
+}
+
+func TestWriteHistogramFloatSample(t *testing.T) {
+	ss := newStatsdServer(t)
+	defer ss.Close()
+
+	prefix := "samaritan"
+	s := New(ss.Addr(), prefix)
+
+	h := stats.NewHistogram(nil, "haha", "", nil)
+	s.WriteHistogramFloatSample(h, 0.25)
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, ss.Content(), fmt.Sprintf("%s.%s.haha:0.25|ms\n", prefix, getHostname()))
+}
+
+func TestStoreClose(t *testing.T) {
+	ss := newStatsdServer(t)
+	defer ss.Close()
+
+	prefix := "samaritan"
+	s := New(ss.Addr(), prefix)
+	store := stats.NewStore(stats.NewStoreOption().WithSinks(s))
+	store.CreateScope("").Counter("foo").Inc()
+	assert.NoError(t, store.Close(context.Background()))
+	// the metrics buffered by the final flush are sent out by the client
+	time.Sleep(clientFlushPeriod * 2)
+	assert.Contains(t, ss.Content(), fmt.Sprintf("%s.%s.foo:1|c", prefix, getHostname()))
+}
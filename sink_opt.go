@@ -0,0 +1,66 @@
+package stats
+
+import "time"
+
+// SinkOption contains options of a Sink registered to the Store.
+type SinkOption struct {
+	// FlushInterval is the interval at which the sink is flushed, zero
+	// means the flush interval of the store.
+	FlushInterval time.Duration
+	// FlushTimeout is the max duration a flush of the sink could take, after
+	// that the flush is reported as timed out and the flushes of sink are
+	// skipped until it returns. Zero means no timeout.
+	FlushTimeout time.Duration
+	// Name identifies the sink in the self-metrics named stats.sink.<name>.*,
+	// it defaults to the name of NamedSink or sink<index> otherwise, and the
+	// default one is suffixed with <index> if another sink has taken it.
+	Name string
+	// SampleQueueSize is the capacity of the queue buffering the histogram
+	// samples written to the sink, and SampleDropPolicy determines which
+	// sample is dropped when the queue is full.
+	SampleQueueSize  int
+	SampleDropPolicy DropPolicy
+}
+
+// DropPolicy determines which sample is dropped when a queue is full.
+type DropPolicy int
+
+// The supported drop policies.
+const (
+	DropNewest DropPolicy = iota // drops the sample being enqueued
+	DropOldest                   // drops the head of queue to make room
+)
+
+const defaultSampleQueueSize = 1024
+
+// NewSinkOption creates a SinkOption which follows the flush interval of the store,
+// with SampleQueueSize set to 1024 and DropNewest policy.
+func NewSinkOption() *SinkOption {
+	return &SinkOption{SampleQueueSize: defaultSampleQueueSize}
+}
+
+// WithFlushInterval returns a SinkOption that sets flush interval for the sink.
+func (opt *SinkOption) WithFlushInterval(interval time.Duration) *SinkOption {
+	opt.FlushInterval = interval
+	return opt
+}
+
+// WithFlushTimeout returns a SinkOption that sets flush timeout for the sink.
+func (opt *SinkOption) WithFlushTimeout(timeout time.Duration) *SinkOption {
+	opt.FlushTimeout = timeout
+	return opt
+}
+
+// WithName returns a SinkOption that sets name for the sink.
+func (opt *SinkOption) WithName(name string) *SinkOption {
+	opt.Name = name
+	return opt
+}
+
+// WithSampleQueue returns a SinkOption that sets the size and drop policy of
+// the histogram sample queue for the sink.
+func (opt *SinkOption) WithSampleQueue(size int, policy DropPolicy) *SinkOption {
+	opt.SampleQueueSize = size
+	opt.SampleDropPolicy = policy
+	return opt
+}
@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinkOption(t *testing.T) {
+	t.Parallel()
+
+	opt := NewSinkOption()
+	assert.Zero(t, opt.FlushInterval)
+	assert.Zero(t, opt.FlushTimeout)
+	assert.Empty(t, opt.Name)
+	assert.Equal(t, defaultSampleQueueSize, opt.SampleQueueSize)
+	assert.Equal(t, DropNewest, opt.SampleDropPolicy)
+
+	opt.WithFlushInterval(time.Minute).
+		WithFlushTimeout(time.Second).
+		WithName("archive").
+		WithSampleQueue(16, DropOldest)
+	assert.Equal(t, time.Minute, opt.FlushInterval)
+	assert.Equal(t, time.Second, opt.FlushTimeout)
+	assert.Equal(t, "archive", opt.Name)
+	assert.Equal(t, 16, opt.SampleQueueSize)
+	assert.Equal(t, DropOldest, opt.SampleDropPolicy)
+}
+
+func TestSinkEntryName(t *testing.T) {
+	t.Parallel()
+
+	var entries []*sinkEntry
+	add := func(sink Sink, o *SinkOption) string {
+		entry := newSinkEntry(sink, o, entries)
+		entries = append(entries, entry)
+		return entry.name
+	}
+	assert.Equal(t, "sink0", add(new(mockSink), nil))
+	assert.Equal(t, "blocking", add(new(blockingSink), nil))
+	assert.Equal(t, "foo", add(new(blockingSink), NewSinkOption().WithName("foo")))
+	// the default names repeated are suffixed with the index
+	assert.Equal(t, "blocking3", add(new(blockingSink), nil))
+	assert.Equal(t, "sink4", add(new(mockSink), nil))
+}
+
+func TestStoreSinkNames(t *testing.T) {
+	sink1 := &blockingSink{unblock: make(chan struct{})}
+	sink2 := &blockingSink{unblock: make(chan struct{})}
+	close(sink1.unblock)
+	close(sink2.unblock)
+	store := NewStore(NewStoreOption().WithSinks(sink1, sink2))
+	assert.NoError(t, store.Flush())
+
+	// the self-metrics of the sinks are distinct
+	scope := store.getStatsScope()
+	assert.NotZero(t, scope.Gauge("sink.blocking.last_success_timestamp").Value())
+	assert.NotZero(t, scope.Gauge("sink.blocking1.last_success_timestamp").Value())
+}
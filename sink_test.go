@@ -12,7 +12,7 @@ func TestMetricsSnapshot(t *testing.T) {
 		gauge1.Set(1)
 
 		gauges := []*Gauge{gauge1}
-		snapshot := newMetricsSnapshot(gauges, nil, nil)
+		snapshot := newMetricsSnapshot(gauges, nil, nil, nil, nil)
 		assert.Len(t, snapshot.Gauges(), 1)
 	})
 
@@ -21,7 +21,7 @@ func TestMetricsSnapshot(t *testing.T) {
 		counter1.Inc()
 
 		counters := []*Counter{counter1}
-		snapshot := newMetricsSnapshot(nil, counters, nil)
+		snapshot := newMetricsSnapshot(nil, nil, nil, counters, nil)
 		assert.Len(t, snapshot.Counters(), 1)
 		assert.EqualValues(t, 1, snapshot.Counters()[0].IntervalValue())
 
@@ -35,7 +35,7 @@ func TestMetricsSnapshot(t *testing.T) {
 		histogram1.Record(1)
 
 		histograms := []*Histogram{histogram1}
-		snapshot := newMetricsSnapshot(nil, nil, histograms)
+		snapshot := newMetricsSnapshot(nil, nil, nil, nil, histograms)
 		assert.Len(t, snapshot.Histograms(), 1)
 		assert.EqualValues(t, 1, snapshot.Histograms()[0].IntervalStatistics().SampleCount())
 
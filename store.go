@@ -11,56 +11,281 @@ import (
 // Store is a storage for all known counters, gauges and histograms.
 type Store struct {
 	mu            sync.RWMutex
+	flushMu       sync.Mutex // serializes the flushes
 	flushInterval time.Duration
+	histWindow    time.Duration
+	metricTTL     uint32
+	matcher       *StatsMatcher
 	tp            *TagProducer
-	sinks         atomic.Value // []Sink
+	sinks         atomic.Value // []*sinkEntry
 
-	scopes map[string]*Scope
-	errors chan error
+	// cardinality limits
+	maxMetrics         int64
+	maxMetricsPerScope int64
+	numMetrics         int64
+	releases           int64 // the number of releases of metrics
+	scopeMetrics       bool  // expose the number of metrics of every scope
+
+	statsScopeOnce sync.Once
+	statsScope     *Scope // holds the metrics of store itself
+
+	descsLock sync.Mutex
+	descs     atomic.Value // map[string]Description
+
+	scopes       map[string]*Scope
+	errors       chan error
+	errorHandler func(error)
+
+	flushNow     chan struct{}
+	sinksChanged chan struct{}
+
+	closed int32
+	done   chan struct{}
 }
 
+const (
+	statsScopeName     = "stats."
+	overflowMetricName = "__overflow__"
+)
+
 // NewStore returns a stats storage.
+// NOTE: The store must be closed by Close once it's not used, which releases
+// the workers writing the histogram samples to sinks.
 func NewStore(o *StoreOption) *Store {
 	if o == nil {
 		o = NewStoreOption()
 	}
 	store := &Store{
 		flushInterval: o.FlushInterval,
+		histWindow:    o.HistogramWindow,
+		matcher:       o.Matcher,
+		scopeMetrics:  o.ScopeMetrics,
 		scopes:        make(map[string]*Scope),
-		errors:        make(chan error),
+		errors:        make(chan error, maxInt(o.ErrorBufferSize, 0)),
+		errorHandler:  o.ErrorHandler,
+		flushNow:      make(chan struct{}, 1),
+		sinksChanged:  make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	if o.MetricTTL > 0 {
+		store.metricTTL = uint32(o.MetricTTL)
+	}
+	if o.MaxMetrics > 0 {
+		store.maxMetrics = int64(o.MaxMetrics)
+	}
+	if o.MaxMetricsPerScope > 0 {
+		store.maxMetricsPerScope = int64(o.MaxMetricsPerScope)
 	}
-	store.sinks.Store(o.Sinks)
+	entries := make([]*sinkEntry, 0, len(o.Sinks))
+	for _, sink := range o.Sinks {
+		entries = append(entries, newSinkEntry(sink, nil, entries))
+	}
+	store.sinks.Store(entries)
 	return store
 }
 
-// Errors returns chan receiving errors occurred during flushing.
+// Errors returns chan receiving errors occurred during flushing, the errors
+// are of type *FlushError. The errors which can't be received or buffered
+// immediately are dropped and counted by stats.errors_dropped. Nothing is
+// delivered to the chan if the store has an ErrorHandler.
 func (store *Store) Errors() <-chan error {
 	return store.errors
 }
 
-// FlushingLoop flushes stats to remote destinations(defined by Sinks) at an interval, it blocks untils ctx canceled.
+// FlushingLoop flushes stats to remote destinations(defined by Sinks) at an interval, it blocks untils ctx canceled
+// or the store closed. The sinks registered with their own flush interval are flushed at that interval.
 // NOTE: this function must be called or metrics won't be sent out.
 func (store *Store) FlushingLoop(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	started := make(map[*sinkEntry]bool)
+	startSinkLoops := func() {
+		for _, entry := range store.sinkEntries() {
+			if entry.interval <= 0 || started[entry] {
+				continue
+			}
+			started[entry] = true
+			wg.Add(1)
+			go func(entry *sinkEntry) {
+				defer wg.Done()
+				store.sinkFlushingLoop(ctx, entry)
+			}(entry)
+		}
+	}
+	startSinkLoops()
+
 	ticker := time.NewTicker(store.flushInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			ticker.Stop()
 			return
+		case <-store.done:
+			return
+		case <-store.sinksChanged:
+			startSinkLoops()
+		case <-store.flushNow:
+			store.Flush() //nolint:errcheck
 		case <-ticker.C:
-			// make metrics snashot
-			guages := store.Gauges()
-			counters := store.Counters()
-			histograms := store.Histograms()
-			snapshot := newMetricsSnapshot(guages, counters, histograms)
-
-			// flush metrics to the registerd sinks
-			sinks := store.Sinks()
-			for _, sink := range sinks {
-				err := sink.Flush(snapshot)
-				store.sendError(err)
-			}
+			store.flush(ctx, false, false) //nolint:errcheck
+		}
+	}
+}
+
+// sinkFlushingLoop flushes the sink at its own interval.
+func (store *Store) sinkFlushingLoop(ctx context.Context, entry *sinkEntry) {
+	ticker := time.NewTicker(entry.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-store.done:
+			return
+		case <-ticker.C:
+			store.sendError(store.flushSink(ctx, entry, false))
+		}
+	}
+}
+
+// FlushNow requests the flushing loop to flush all the sinks immediately
+// regardless of their intervals, it doesn't wait for the flush. The requests
+// made before the pending one is served are coalesced.
+func (store *Store) FlushNow() {
+	select {
+	case store.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// Flush flushes the metrics to all the sinks synchronously. The errors are
+// delivered to Errors as the periodic flushes do, and the first one is returned.
+func (store *Store) Flush() error {
+	return store.flush(context.Background(), true, false)
+}
+
+// flush refreshes the interval values of metrics, flushes them to the sinks
+// following the store interval, or all the sinks if all is true, and then
+// evicts the idle metrics. If wait is true, the sinks under in-flight flushes
+// are flushed once the in-flight ones return rather than being skipped, and
+// the wait is bounded by ctx.
+func (store *Store) flush(ctx context.Context, all, wait bool) error {
+	store.flushMu.Lock()
+	defer store.flushMu.Unlock()
+
+	// refresh the interval values presented by the metrics themselves
+	refreshIntervals(store.Counters(), store.Histograms())
+
+	// flush metrics to the registerd sinks concurrently, so that a slow
+	// sink doesn't delay the others.
+	entries := store.sinkEntries()
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		if !all && entry.interval > 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, entry *sinkEntry) {
+			defer wg.Done()
+			errs[i] = store.flushSink(ctx, entry, wait)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	var firstErr error
+	for _, err := range errs {
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
+		store.sendError(err)
+	}
+
+	store.evictIdleMetrics()
+	return firstErr
+}
+
+// flushSink flushes the metrics to the sink, the interval values are
+// calculated since the last flush of the sink. The sink is skipped if its
+// previous flush hasn't returned, unless wait is true. The error returned is
+// a *FlushError.
+func (store *Store) flushSink(ctx context.Context, entry *sinkEntry, wait bool) error {
+	done, err := entry.beginFlush(ctx, wait)
+	if err != nil {
+		return newFlushError(entry.name, err)
+	}
+	snapshot := entry.latch.snapshot(
+		store.Gauges(),
+		store.FloatGauges(),
+		store.IntGauges(),
+		store.Counters(),
+		store.Histograms(),
+	)
+	snapshot.describer = store
+	if err := entry.flush(store, snapshot, done); err != nil {
+		return newFlushError(entry.name, err)
+	}
+	return nil
+}
+
+// Close stops the flushing loop and flushes the metrics of the last interval,
+// the final flush of a sink starts once its in-flight flush returns. It waits
+// for the flushes and the queued histogram samples until ctx done. After
+// that, the sinks which implement io.Closer are closed, a sink is never closed
+// under its in-flight flush, which is left to the background if ctx is done
+// first. Only the first call takes effect.
+func (store *Store) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&store.closed, 0, 1) {
+		return nil
+	}
+	close(store.done)
+
+	var err error
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- store.flush(ctx, true, true)
+	}()
+	select {
+	case err = <-flushed:
+	case <-ctx.Done():
+		// the sinks are closed once the final flush returns
+		go func() {
+			<-flushed
+			store.closeSinks(context.Background()) //nolint:errcheck
+		}()
+		return ctx.Err()
+	}
+
+	if cerr := store.closeSinks(ctx); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeSinks closes the sinks after their in-flight flushes and queued
+// samples are done, the first error is returned.
+func (store *Store) closeSinks(ctx context.Context) error {
+	var err error
+	histograms := store.Histograms()
+	for _, entry := range store.sinkEntries() {
+		if cerr := entry.close(ctx, store, histograms); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// evictIdleMetrics removes the metrics which haven't been touched for
+// the configured number of flush intervals.
+func (store *Store) evictIdleMetrics() {
+	if store.metricTTL == 0 {
+		return
+	}
+	for _, scope := range store.Scopes() {
+		scope.evictIdleMetrics(store.metricTTL)
 	}
 }
 
@@ -68,9 +293,14 @@ func (store *Store) sendError(err error) {
 	if err == nil {
 		return
 	}
+	if store.errorHandler != nil {
+		store.errorHandler(err)
+		return
+	}
 	select {
 	case store.errors <- err:
 	default:
+		store.getStatsScope().Counter("errors_dropped").Inc()
 	}
 }
 
@@ -95,60 +325,255 @@ func (store *Store) setTagProducer(tp *TagProducer) {
 	store.tp = tp
 }
 
-// AddSink adds a sink
+// AddSink adds a sink flushed at the interval of store.
 func (store *Store) AddSink(sink Sink) {
+	store.AddSinkWithOption(sink, nil)
+}
+
+// AddSinkWithOption adds a sink with the given option, e.g. its own flush
+// interval. The counters of the sink count from the time it's added.
+func (store *Store) AddSinkWithOption(sink Sink, o *SinkOption) {
+	counters := store.Counters()
+
 	store.mu.Lock()
-	defer store.mu.Unlock()
+	entries := store.sinkEntries()
+	entry := newSinkEntry(sink, o, entries)
+	entry.latch.prime(counters)
+	tmpEntries := make([]*sinkEntry, len(entries), len(entries)+1)
+	copy(tmpEntries, entries)
+	tmpEntries = append(tmpEntries, entry)
+	store.sinks.Store(tmpEntries)
+	store.mu.Unlock()
 
-	sinks := store.Sinks()
-	tmpSinks := make([]Sink, len(sinks), len(sinks)+1)
-	copy(tmpSinks, sinks)
-	tmpSinks = append(tmpSinks, sink)
-	store.sinks.Store(tmpSinks)
+	select {
+	case store.sinksChanged <- struct{}{}:
+	default:
+	}
 }
 
 // Sinks return all known sinks.
 func (store *Store) Sinks() []Sink {
-	return store.sinks.Load().([]Sink)
+	entries := store.sinkEntries()
+	sinks := make([]Sink, 0, len(entries))
+	for _, entry := range entries {
+		sinks = append(sinks, entry.sink)
+	}
+	return sinks
+}
+
+// HistogramWindow returns the max sliding window retained by histograms,
+// zero means no window is retained.
+func (store *Store) HistogramWindow() time.Duration {
+	return store.histWindow
+}
+
+func (store *Store) sinkEntries() []*sinkEntry {
+	return store.sinks.Load().([]*sinkEntry)
 }
 
-// CreateScope creates the named Scope.
+// CreateScope creates the named Scope. The name stats is reserved for the
+// metrics of store itself, it panics if the name is used.
 func (store *Store) CreateScope(name string) *Scope {
-	store.mu.Lock()
-	defer store.mu.Unlock()
+	return store.createScope(name, nil, nil, "")
+}
 
+func (store *Store) createScope(name string, tags []*Tag, parent *Scope, childKey string) *Scope {
 	// fix suffix
 	if len(name) > 0 && !strings.HasSuffix(name, ".") {
 		name += "."
 	}
-	scope, ok := store.scopes[name]
+	if name == statsScopeName && len(tags) == 0 {
+		panic("stats is a reserved scope name")
+	}
+	return store.newScope(name, tags, parent, childKey)
+}
+
+// newScope creates the scope of the name with suffix, or returns the existing
+// one with its reference increased.
+func (store *Store) newScope(name string, tags []*Tag, parent *Scope, childKey string) *Scope {
+	store.mu.Lock()
+	key := name + tagsString(tags)
+	scope, ok := store.scopes[key]
 	if !ok {
-		scope = newScope(name, store)
-		store.scopes[name] = scope
+		scope = newTaggedScope(name, tags, store)
+		// the tagged children share the limit of the parent
+		if parent != nil && parent.prefix == name {
+			scope.quota = parent.quota
+		}
+		store.scopes[key] = scope
+	}
+	if scope.parent == nil && parent != nil {
+		scope.parent = parent
+		scope.childKey = childKey
 	}
 	scope.refCount++
+	store.mu.Unlock()
+
+	if !ok && scope.quota == scope && store.exposeScopeMetrics() && key != statsScopeName {
+		store.getStatsScope().
+			GaugeWithTags("scope_metrics", &Tag{Name: "scope", Value: key}).
+			SetFunc(scope.quotaMetrics)
+	}
 	return scope
 }
 
 // DeleteScope deletes the named Scope.
 func (store *Store) DeleteScope(scope *Scope) {
+	if scope.overflow {
+		return
+	}
+	var deleted []*Scope
 	store.mu.Lock()
-	defer store.mu.Unlock()
-	store.deleteScopeWithAllChilds(scope)
+	store.deleteScopeWithAllChilds(scope, &deleted)
+	store.mu.Unlock()
+
+	// detach the deleted scopes from their parents, it must be done
+	// without holding the store lock to keep the lock order with NewChild.
+	for _, scope := range deleted {
+		if scope.parent != nil {
+			scope.parent.removeChild(scope.childKey, scope)
+		}
+		scope.markDeleted()
+		store.releaseMetrics(scope, int(scope.numMetrics()))
+		if scope.quota == scope && store.exposeScopeMetrics() && scope.key() != statsScopeName {
+			store.getStatsScope().RemoveGauge("scope_metrics", &Tag{Name: "scope", Value: scope.key()})
+		}
+	}
+}
+
+// getStatsScope returns the scope holding the metrics of store itself,
+// it is exempt from the cardinality limits and not counted.
+func (store *Store) getStatsScope() *Scope {
+	store.statsScopeOnce.Do(func() {
+		scope := store.newScope(statsScopeName, nil, nil, "")
+		atomic.StoreInt32(&scope.unlimited, 1)
+		store.statsScope = scope
+	})
+	return store.statsScope
+}
+
+func (store *Store) cardinalityLimited() bool {
+	return store.maxMetrics > 0 || store.maxMetricsPerScope > 0
+}
+
+func (store *Store) exposeScopeMetrics() bool {
+	return store.scopeMetrics && store.cardinalityLimited()
+}
+
+// acquireMetric reserves a slot for a new metric within the given scope, it
+// returns false when the cardinality limits are exceeded. The metrics count
+// against the limit of the quota of scope, and the overflow metrics don't
+// occupy any slot.
+func (store *Store) acquireMetric(scope *Scope, name string) bool {
+	if scope.overflow {
+		return false
+	}
+	if name == overflowMetricName || atomic.LoadInt32(&scope.unlimited) == 1 {
+		return true
+	}
+	if !store.cardinalityLimited() {
+		scope.addMetrics(1)
+		atomic.AddInt64(&store.numMetrics, 1)
+		return true
+	}
+
+	if !acquireSlot(&scope.quota.quotaVal, store.maxMetricsPerScope) {
+		return false
+	}
+	if !acquireSlot(&store.numMetrics, store.maxMetrics) {
+		atomic.AddInt64(&scope.quota.quotaVal, -1)
+		return false
+	}
+	atomic.AddInt64(&scope.numMetricsVal, 1)
+	return true
+}
+
+// limitReached reports whether the new metrics of scope are rejected by the
+// cardinality limits.
+func (store *Store) limitReached(scope *Scope) bool {
+	if scope.overflow {
+		return true
+	}
+	if !store.cardinalityLimited() || atomic.LoadInt32(&scope.unlimited) == 1 {
+		return false
+	}
+	if store.maxMetricsPerScope > 0 &&
+		atomic.LoadInt64(&scope.quota.quotaVal) >= store.maxMetricsPerScope {
+		return true
+	}
+	return store.metricsExhausted()
+}
+
+// metricsExhausted reports whether the store is full of metrics.
+func (store *Store) metricsExhausted() bool {
+	return store.maxMetrics > 0 && atomic.LoadInt64(&store.numMetrics) >= store.maxMetrics
+}
+
+// releaseMetric releases the slot occupied by the removed metric of scope.
+func (store *Store) releaseMetric(scope *Scope, name string) {
+	if name == overflowMetricName {
+		return
+	}
+	store.releaseMetrics(scope, 1)
+}
+
+// reclaimMetric occupies the slot again for the revived metric of scope, the
+// limits aren't checked as the metric has been admitted before.
+func (store *Store) reclaimMetric(scope *Scope, name string) {
+	if name == overflowMetricName || atomic.LoadInt32(&scope.unlimited) == 1 {
+		return
+	}
+	scope.addMetrics(1)
+	atomic.AddInt64(&store.numMetrics, 1)
+}
+
+// releaseMetrics releases the slots of scope, which outdates the keys
+// rejected by the limits.
+func (store *Store) releaseMetrics(scope *Scope, n int) {
+	if n == 0 || atomic.LoadInt32(&scope.unlimited) == 1 {
+		return
+	}
+	scope.addMetrics(-int64(n))
+	atomic.AddInt64(&store.numMetrics, -int64(n))
+	atomic.AddInt64(&store.releases, 1)
+}
+
+func (store *Store) loadReleases() int64 {
+	return atomic.LoadInt64(&store.releases)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
-func (store *Store) deleteScopeWithAllChilds(scope *Scope) {
-	name := scope.Name()
-	scope, ok := store.scopes[name]
+func acquireSlot(count *int64, max int64) bool {
+	for {
+		n := atomic.LoadInt64(count)
+		if max > 0 && n >= max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(count, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (store *Store) deleteScopeWithAllChilds(scope *Scope, deleted *[]*Scope) {
+	key := scope.key()
+	scope, ok := store.scopes[key]
 	if !ok {
 		return
 	}
 	children := scope.loadChildren()
 	for _, child := range children {
-		store.deleteScopeWithAllChilds(child)
+		store.deleteScopeWithAllChilds(child, deleted)
 	}
 	if scope.refCount == 1 {
-		delete(store.scopes, name)
+		delete(store.scopes, key)
+		*deleted = append(*deleted, scope)
 		return
 	}
 	scope.refCount--
@@ -185,6 +610,26 @@ func (store *Store) Gauges() []*Gauge {
 	return gs
 }
 
+// FloatGauges returns all known float gauges.
+func (store *Store) FloatGauges() []*FloatGauge {
+	scopes := store.Scopes()
+	gs := make([]*FloatGauge, 0, len(scopes))
+	for _, scope := range scopes {
+		gs = append(gs, scope.FloatGauges()...)
+	}
+	return gs
+}
+
+// IntGauges returns all known signed gauges.
+func (store *Store) IntGauges() []*IntGauge {
+	scopes := store.Scopes()
+	gs := make([]*IntGauge, 0, len(scopes))
+	for _, scope := range scopes {
+		gs = append(gs, scope.IntGauges()...)
+	}
+	return gs
+}
+
 // Histograms returns all known histograms.
 func (store *Store) Histograms() []*Histogram {
 	scopes := store.Scopes()
@@ -195,10 +640,12 @@ func (store *Store) Histograms() []*Histogram {
 	return hs
 }
 
-func (store *Store) deliverHistogramSampleToSinks(h *Histogram, val uint64) {
-	sinks := store.Sinks()
-	for _, sink := range sinks {
-		sink.WriteHistogramSample(h, val)
+// deliverHistogramSampleToSinks queues the sample for every sink without
+// blocking, the samples are written to the sinks asynchronously.
+func (store *Store) deliverHistogramSampleToSinks(h *Histogram, sample histogramSample) {
+	sample.h = h
+	for _, entry := range store.sinkEntries() {
+		entry.writeSample(store, sample)
 	}
 }
 
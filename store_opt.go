@@ -6,6 +6,39 @@ import "time"
 type StoreOption struct {
 	FlushInterval time.Duration
 	Sinks         []Sink
+	// MetricTTL is the number of flush intervals a counter or histogram
+	// could stay untouched, after that it will be removed from its scope
+	// until the next update on it. Gauges never expire, as they hold the
+	// current values, remove them explicitly instead. Zero means metrics
+	// never expire.
+	MetricTTL int
+	// MaxMetrics is the max number of metrics in the store, and
+	// MaxMetricsPerScope is the max number within a single scope. Once
+	// exceeded, new metrics of a scope resolve to the shared overflow metric
+	// named <scope>.__overflow__, and new children resolve to an overflow
+	// scope. Tagged scopes count against the limit of the scope they're
+	// tagged from. The distinct rejected names are counted by the counter
+	// stats.cardinality_rejected. Zero means unlimited.
+	MaxMetrics         int
+	MaxMetricsPerScope int
+	// ScopeMetrics indicates whether to expose the number of metrics of
+	// every scope as the gauge stats.scope_metrics{scope=<scope>} when the
+	// cardinality is limited. It's disabled by default, as the gauges grow
+	// with the scopes.
+	ScopeMetrics bool
+	// Matcher determines which metrics should be instantiated, the rejected
+	// ones are replaced by null metrics which discard all the updates.
+	Matcher *StatsMatcher
+	// ErrorHandler is called synchronously with every error occurred during
+	// flushing, e.g. a *FlushError, instead of delivering it to Store.Errors.
+	ErrorHandler func(error)
+	// ErrorBufferSize is the number of errors buffered by Store.Errors for
+	// the slow receivers, zero means unbuffered.
+	ErrorBufferSize int
+	// HistogramWindow is the max duration of the sliding window retained by
+	// histograms, which is made up of the interval statistics of the last
+	// flush intervals. Zero means no window is retained.
+	HistogramWindow time.Duration
 }
 
 const defaultStoreFlushInterval = time.Second * 5
@@ -26,3 +59,53 @@ func (opt *StoreOption) WithSinks(sinks ...Sink) *StoreOption {
 	opt.Sinks = sinks
 	return opt
 }
+
+// WithMetricTTL returns a StoreOption that sets the number of flush intervals
+// after which the untouched counters and histograms are evicted.
+func (opt *StoreOption) WithMetricTTL(n int) *StoreOption {
+	opt.MetricTTL = n
+	return opt
+}
+
+// WithMaxMetrics returns a StoreOption that sets the max number of metrics in the store.
+func (opt *StoreOption) WithMaxMetrics(n int) *StoreOption {
+	opt.MaxMetrics = n
+	return opt
+}
+
+// WithMaxMetricsPerScope returns a StoreOption that sets the max number of metrics within a scope.
+func (opt *StoreOption) WithMaxMetricsPerScope(n int) *StoreOption {
+	opt.MaxMetricsPerScope = n
+	return opt
+}
+
+// WithScopeMetrics returns a StoreOption that sets whether to expose the
+// number of metrics of every scope.
+func (opt *StoreOption) WithScopeMetrics(enabled bool) *StoreOption {
+	opt.ScopeMetrics = enabled
+	return opt
+}
+
+// WithMatcher returns a StoreOption that sets the stats matcher for the store.
+func (opt *StoreOption) WithMatcher(m *StatsMatcher) *StoreOption {
+	opt.Matcher = m
+	return opt
+}
+
+// WithHistogramWindow returns a StoreOption that sets the max sliding window of histograms.
+func (opt *StoreOption) WithHistogramWindow(window time.Duration) *StoreOption {
+	opt.HistogramWindow = window
+	return opt
+}
+
+// WithErrorHandler returns a StoreOption that sets the handler of flush errors.
+func (opt *StoreOption) WithErrorHandler(handler func(error)) *StoreOption {
+	opt.ErrorHandler = handler
+	return opt
+}
+
+// WithErrorBufferSize returns a StoreOption that sets the buffer size of Store.Errors.
+func (opt *StoreOption) WithErrorBufferSize(n int) *StoreOption {
+	opt.ErrorBufferSize = n
+	return opt
+}
@@ -19,6 +19,33 @@ func TestWithFlushIntervalSetsValue(t *testing.T) {
 	assert.Equal(t, newInterval, opt.FlushInterval)
 }
 
+func TestWithMetricTTLSetsValue(t *testing.T) {
+	t.Parallel()
+
+	opt := NewStoreOption()
+	assert.Equal(t, 0, opt.MetricTTL)
+	opt.WithMetricTTL(3)
+	assert.Equal(t, 3, opt.MetricTTL)
+}
+
+func TestWithScopeMetricsSetsValue(t *testing.T) {
+	t.Parallel()
+
+	opt := NewStoreOption()
+	assert.False(t, opt.ScopeMetrics)
+	opt.WithScopeMetrics(true)
+	assert.True(t, opt.ScopeMetrics)
+}
+
+func TestWithMatcherSetsValue(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewStatsMatcher([]StringMatcher{{Prefix: "upstream."}}, nil)
+	assert.NoError(t, err)
+	opt := NewStoreOption().WithMatcher(m)
+	assert.Equal(t, m, opt.Matcher)
+}
+
 // func TestWithSinksSetsValue(t *testing.T) {
 // t.Parallel()
 // ctl := gomock.NewController(t)
@@ -34,3 +61,23 @@ func TestWithFlushIntervalSetsValue(t *testing.T) {
 // opt.WithSinks(newSinks...)
 // assert.Equal(t, newSinks, opt.Sinks)
 // }
+
+func TestWithErrorOptionsSetsValue(t *testing.T) {
+	t.Parallel()
+
+	opt := NewStoreOption()
+	assert.Nil(t, opt.ErrorHandler)
+	assert.Equal(t, 0, opt.ErrorBufferSize)
+	opt.WithErrorHandler(func(error) {}).WithErrorBufferSize(16)
+	assert.NotNil(t, opt.ErrorHandler)
+	assert.Equal(t, 16, opt.ErrorBufferSize)
+}
+
+func TestWithHistogramWindowSetsValue(t *testing.T) {
+	t.Parallel()
+
+	opt := NewStoreOption()
+	assert.Zero(t, opt.HistogramWindow)
+	opt.WithHistogramWindow(time.Minute * 15)
+	assert.Equal(t, time.Minute*15, opt.HistogramWindow)
+}
@@ -2,17 +2,21 @@ package stats
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
 type mockSink struct {
 	flushCallback         func(MetricsSnapshot)
 	flushCalled           bool
-	writeHistSampleCalled bool
+	writeHistSampleCalled int32
 }
 
 func (sink *mockSink) Flush(snapshot MetricsSnapshot) error {
@@ -23,7 +27,51 @@ func (sink *mockSink) Flush(snapshot MetricsSnapshot) error {
 }
 
 func (sink *mockSink) WriteHistogramSample(h *Histogram, val uint64) error {
-	sink.writeHistSampleCalled = true
+	atomic.StoreInt32(&sink.writeHistSampleCalled, 1)
+	return nil
+}
+
+type closableSink struct {
+	mockSink
+	flushErr error
+	flushed  chan struct{}
+	closed   int32
+}
+
+func (sink *closableSink) Flush(snapshot MetricsSnapshot) error {
+	if sink.flushed != nil {
+		<-sink.flushed
+	}
+	sink.mockSink.Flush(snapshot) //nolint:errcheck
+	return sink.flushErr
+}
+
+func (sink *closableSink) Close() error {
+	atomic.StoreInt32(&sink.closed, 1)
+	return nil
+}
+
+func (sink *closableSink) isClosed() bool {
+	return atomic.LoadInt32(&sink.closed) == 1
+}
+
+// findCounter returns the named counter in snapshot, or nil if not found.
+func findCounter(snapshot MetricsSnapshot, name string) *Counter {
+	for _, c := range snapshot.Counters() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// findHistogram returns the named histogram in snapshot, or nil if not found.
+func findHistogram(snapshot MetricsSnapshot, name string) *Histogram {
+	for _, h := range snapshot.Histograms() {
+		if h.Name() == name {
+			return h
+		}
+	}
 	return nil
 }
 
@@ -117,6 +165,22 @@ func TestStoreDeleteScopeWithChildren(t *testing.T) {
 	assert.Equal(t, len(store.Scopes()), 0)
 }
 
+func TestStoreDeleteScopeDetachFromParent(t *testing.T) {
+	store := NewStore(nil)
+	scope := store.CreateScope("upstream")
+	child := scope.NewChild("foo")
+	assert.Equal(t, 1, len(scope.loadChildren()))
+
+	store.DeleteScope(child)
+	assert.Equal(t, 0, len(scope.loadChildren()))
+	assert.Equal(t, 1, len(store.Scopes()))
+
+	// the recreated child must be registered in the store
+	newChild := scope.NewChild("foo")
+	assert.False(t, child == newChild)
+	assert.Equal(t, 2, len(store.Scopes()))
+}
+
 func TestStoreCounters(t *testing.T) {
 	store := NewStore(
 		NewStoreOption().
@@ -151,6 +215,20 @@ func TestStoreGauges(t *testing.T) {
 	assert.Equal(t, len(gs), 3)
 }
 
+func TestStoreFloatAndIntGauges(t *testing.T) {
+	store := NewStore(nil)
+
+	scope1 := store.CreateScope("scope1")
+	scope1.FloatGauge("load").Set(0.5)
+	scope1.IntGauge("delta").Dec()
+
+	scope2 := store.CreateScope("scope2")
+	scope2.FloatGauge("load").Set(1.5)
+
+	assert.Equal(t, 2, len(store.FloatGauges()))
+	assert.Equal(t, 1, len(store.IntGauges()))
+}
+
 func TestStoreHistograms(t *testing.T) {
 	store := NewStore(
 		NewStoreOption().
@@ -187,8 +265,182 @@ func TestStoreDeliverHistogramSampleToSinks(t *testing.T) {
 	h := scope.Histogram("haha")
 	h.Record(uint64(time.Millisecond))
 
-	assert.True(t, sink1.writeHistSampleCalled)
-	assert.True(t, sink2.writeHistSampleCalled)
+	// the samples are written asynchronously
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&sink1.writeHistSampleCalled) == 1 &&
+			atomic.LoadInt32(&sink2.writeHistSampleCalled) == 1
+	}, time.Second, time.Millisecond*10)
+}
+
+type sampleSink struct {
+	mockSink
+	mu      sync.Mutex
+	samples []uint64
+	unblock chan struct{}
+}
+
+func (sink *sampleSink) WriteHistogramSample(h *Histogram, val uint64) error {
+	if sink.unblock != nil {
+		<-sink.unblock
+	}
+	sink.mu.Lock()
+	sink.samples = append(sink.samples, val)
+	sink.mu.Unlock()
+	return nil
+}
+
+type floatSampleSink struct {
+	sampleSink
+	fsamples []float64
+}
+
+func (sink *floatSampleSink) WriteHistogramFloatSample(h *Histogram, val float64) error {
+	sink.mu.Lock()
+	sink.fsamples = append(sink.fsamples, val)
+	sink.mu.Unlock()
+	return nil
+}
+
+func TestStoreDeliverFloatSamples(t *testing.T) {
+	sink1 := new(sampleSink)
+	sink2 := new(floatSampleSink)
+	store := NewStore(NewStoreOption().WithSinks(sink1, sink2))
+	h := store.CreateScope("").Histogram("ratio")
+	h.RecordFloat(0.75)
+	h.RecordScaled(25, -1)
+	h.Record(3)
+	assert.NoError(t, store.Close(context.Background()))
+
+	// the float samples are rounded for the sinks not accepting them, the
+	// samples following are the flush latencies of sinks.
+	assert.Equal(t, []uint64{1, 3, 3}, sink1.samples[:3])
+	assert.Equal(t, []float64{0.75, 2.5}, sink2.fsamples[:2])
+	assert.Equal(t, []uint64{3}, sink2.samples)
+}
+
+func TestSinkSampleQueue(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		entry := newSinkEntry(new(mockSink), NewSinkOption().WithSampleQueue(2, DropNewest), nil)
+		assert.True(t, entry.enqueueSample(histogramSample{val: 1}))
+		assert.True(t, entry.enqueueSample(histogramSample{val: 2}))
+		assert.False(t, entry.enqueueSample(histogramSample{val: 3}))
+		assert.Equal(t, uint64(1), (<-entry.samples).val)
+		assert.Equal(t, uint64(2), (<-entry.samples).val)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		entry := newSinkEntry(new(mockSink), NewSinkOption().WithSampleQueue(2, DropOldest), nil)
+		assert.True(t, entry.enqueueSample(histogramSample{val: 1}))
+		assert.True(t, entry.enqueueSample(histogramSample{val: 2}))
+		assert.False(t, entry.enqueueSample(histogramSample{val: 3}))
+		assert.Equal(t, uint64(2), (<-entry.samples).val)
+		assert.Equal(t, uint64(3), (<-entry.samples).val)
+	})
+
+	t.Run("blocked sink", func(t *testing.T) {
+		sink := &sampleSink{unblock: make(chan struct{})}
+		store := NewStore(nil)
+		store.AddSinkWithOption(sink, NewSinkOption().WithName("slow").WithSampleQueue(1, DropNewest))
+		h := store.CreateScope("").Histogram("foo")
+		for i := 0; i < 10; i++ {
+			h.Record(uint64(i))
+		}
+		// the worker holds at most one sample and the queue holds another
+		assert.True(t, store.getStatsScope().Counter("sink.slow.samples_dropped").Value() >= 8)
+		// the dropped samples are counted without allocations
+		assert.Zero(t, testing.AllocsPerRun(100, func() { h.Record(1) }))
+
+		// the queued samples are written before the store closed
+		close(sink.unblock)
+		assert.NoError(t, store.Close(context.Background()))
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		assert.Equal(t, uint64(0), sink.samples[0])
+		assert.True(t, len(sink.samples) <= 2)
+	})
+}
+
+// flushBlockingSampleSink is a sampleSink blocking in Flush until flushed
+// closed, and the flushes started are signalled by flushing.
+type flushBlockingSampleSink struct {
+	sampleSink
+	flushing chan struct{}
+	flushed  chan struct{}
+}
+
+func (sink *flushBlockingSampleSink) Flush(snapshot MetricsSnapshot) error {
+	select {
+	case sink.flushing <- struct{}{}:
+	default:
+	}
+	<-sink.flushed
+	return nil
+}
+
+func TestSinkSamplesDuringFinalFlush(t *testing.T) {
+	sink := &flushBlockingSampleSink{flushing: make(chan struct{}, 1), flushed: make(chan struct{})}
+	store := NewStore(NewStoreOption().WithSinks(sink))
+	h := store.CreateScope("").Histogram("foo")
+	h.Record(1)
+
+	closed := make(chan error, 1)
+	go func() { closed <- store.Close(context.Background()) }()
+	<-sink.flushing
+	// the samples are still written to sink during the final flush
+	h.Record(2)
+	close(sink.flushed)
+	assert.NoError(t, <-closed)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.Equal(t, []uint64{1, 2}, sink.samples[:2])
+	assert.Zero(t, store.getStatsScope().Counter("sink.sink0.samples_dropped").Value())
+}
+
+type discardingSink struct {
+	mockSink
+}
+
+func (sink *discardingSink) DiscardsHistogramSamples() bool {
+	return true
+}
+
+func TestStoreSnapshotWindow(t *testing.T) {
+	sink := new(mockSink)
+	var windowCount uint64
+	sink.flushCallback = func(snapshot MetricsSnapshot) {
+		h := findHistogram(snapshot, "rq_time")
+		windowCount = h.WindowStatistics(time.Minute).SampleCount()
+	}
+	store := NewStore(NewStoreOption().WithSinks(sink).WithHistogramWindow(time.Minute))
+	h := store.CreateScope("").Histogram("rq_time")
+	h.Record(1)
+	assert.NoError(t, store.Flush())
+	h.Record(2)
+	assert.NoError(t, store.Flush())
+	// the snapshot carries the window of histogram
+	assert.Equal(t, uint64(2), windowCount)
+}
+
+func TestSinkDiscardingSamples(t *testing.T) {
+	sink := new(discardingSink)
+	store := NewStore(NewStoreOption().WithSinks(sink))
+	entry := store.sinkEntries()[0]
+	assert.Nil(t, entry.samples)
+
+	store.CreateScope("").Histogram("foo").Record(1)
+	assert.Zero(t, atomic.LoadInt32(&entry.workerActive))
+	assert.Zero(t, atomic.LoadInt32(&sink.writeHistSampleCalled))
+	assert.NoError(t, store.Close(context.Background()))
+
+	// the samples are ignored after closing
+	store = NewStore(nil)
+	store.AddSinkWithOption(new(mockSink), NewSinkOption().WithSampleQueue(1, DropNewest))
+	assert.NoError(t, store.Close(context.Background()))
+	h := store.CreateScope("").Histogram("foo")
+	for i := 0; i < 10; i++ {
+		h.Record(1)
+	}
+	assert.Zero(t, store.getStatsScope().Counter("sink.sink0.samples_dropped").Value())
 }
 
 func TestStorePeroidcFlush(t *testing.T) {
@@ -212,6 +464,518 @@ func TestStorePeroidcFlush(t *testing.T) {
 	store.FlushingLoop(ctx)
 }
 
+func TestStoreFlushGaugeFunc(t *testing.T) {
+	var called int
+	sink1 := new(mockSink)
+	sink1.flushCallback = func(snapshot MetricsSnapshot) {
+		assert.Equal(t, 1, len(snapshot.Gauges()))
+		assert.Equal(t, uint64(42), snapshot.Gauges()[0].Value())
+	}
+
+	opt := NewStoreOption().
+		WithFlushInterval(time.Millisecond * 100).
+		WithSinks(sink1)
+	store := NewStore(opt)
+
+	scope := store.CreateScope("queue")
+	scope.GaugeFunc("depth", func() uint64 {
+		called++
+		return 42
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*150, cancel)
+	store.FlushingLoop(ctx)
+	assert.NotZero(t, called)
+}
+
+func TestStoreMetricTTL(t *testing.T) {
+	opt := NewStoreOption().
+		WithFlushInterval(time.Millisecond * 10).
+		WithMetricTTL(2)
+	store := NewStore(opt)
+
+	scope := store.CreateScope("client")
+	c := scope.Counter("foo")
+	c.Inc()
+	scope.Gauge("bar").Set(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+	store.FlushingLoop(ctx)
+	assert.Equal(t, 0, len(scope.loadCounters()))
+	assert.Empty(t, store.Counters())
+	// the gauges never expire
+	assert.Equal(t, uint64(3), scope.Gauge("bar").Value())
+
+	// the cached handle is registered again on update
+	c.Inc()
+	assert.True(t, c == scope.Counter("foo"))
+	assert.Equal(t, []*Counter{c}, store.Counters())
+	assert.Equal(t, uint64(2), c.Value())
+}
+
+func TestStoreCardinalityLimits(t *testing.T) {
+	t.Run("per scope", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetricsPerScope(2).WithScopeMetrics(true))
+		scope := store.CreateScope("client")
+		c1 := scope.Counter("c1")
+		g1 := scope.Gauge("g1")
+		c2 := scope.Counter("c2")
+		h1 := scope.HistogramWithTags("h1", &Tag{Name: "k", Value: "v"})
+		assert.Equal(t, "client.c1", c1.Name())
+		assert.Equal(t, "client.g1", g1.Name())
+		assert.Equal(t, "client.__overflow__", c2.Name())
+		assert.Equal(t, "client.__overflow__", h1.Name())
+		assert.True(t, c2 == scope.Counter("c3"))
+
+		statsScope := store.getStatsScope()
+		assert.Equal(t, uint64(3), statsScope.Counter("cardinality_rejected").Value())
+		g := statsScope.GaugeWithTags("scope_metrics", &Tag{Name: "scope", Value: "client."})
+		// the overflow metrics are not counted
+		assert.Equal(t, uint64(2), g.Value())
+
+		// other scopes are not affected
+		other := store.CreateScope("server")
+		assert.Equal(t, "server.c1", other.Counter("c1").Name())
+
+		// the slot is released after removing
+		scope.RemoveCounter("c1")
+		assert.Equal(t, "client.c2", scope.Counter("c2").Name())
+	})
+
+	t.Run("total", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetrics(2))
+		scope1 := store.CreateScope("client")
+		scope2 := store.CreateScope("server")
+		assert.Equal(t, "client.c1", scope1.Counter("c1").Name())
+		assert.Equal(t, "server.c1", scope2.Counter("c1").Name())
+		assert.Equal(t, "client.__overflow__", scope1.Counter("c2").Name())
+		assert.Equal(t, "server.__overflow__", scope2.Gauge("g1").Name())
+		// the metrics of store itself are exempt
+		assert.Equal(t, uint64(2), store.getStatsScope().Counter("cardinality_rejected").Value())
+
+		store.DeleteScope(scope2)
+		assert.Equal(t, "client.c2", scope1.Counter("c2").Name())
+		// the number of metrics of every scope isn't exposed by default
+		assert.Equal(t, 0, len(store.getStatsScope().Gauges()))
+	})
+
+	t.Run("scope metrics", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetrics(2).WithScopeMetrics(true))
+		scope1 := store.CreateScope("client")
+		scope2 := store.CreateScope("server")
+		assert.Equal(t, 2, len(store.getStatsScope().Gauges()))
+		scope1.Counter("c1")
+		assert.Equal(t, uint64(1), store.getStatsScope().
+			GaugeWithTags("scope_metrics", &Tag{Name: "scope", Value: "client."}).Value())
+		store.DeleteScope(scope2)
+		assert.Equal(t, 1, len(store.getStatsScope().Gauges()))
+	})
+
+	t.Run("tagged scopes", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetricsPerScope(2).WithScopeMetrics(true))
+		scope := store.CreateScope("client")
+		for i := 0; i < 10; i++ {
+			scope.Tagged(map[string]string{"id": strconv.Itoa(i)}).Counter("rq_total").Inc()
+		}
+		// the tagged scopes share the limit, and the rest resolve to the overflow scope
+		assert.Equal(t, 4, len(store.Scopes())) // client, two tagged ones and stats
+		overflow := scope.Tagged(map[string]string{"id": "10"})
+		assert.True(t, overflow == scope.Tagged(map[string]string{"id": "11"}))
+		assert.True(t, overflow == overflow.NewChild("upstream"))
+		c := overflow.Counter("rq_total")
+		assert.Equal(t, "client.__overflow__", c.Name())
+		assert.True(t, c == scope.Counter("c1"))
+		assert.Equal(t, uint64(8), c.Value())
+		g := store.getStatsScope().GaugeWithTags("scope_metrics", &Tag{Name: "scope", Value: "client."})
+		assert.Equal(t, uint64(2), g.Value())
+
+		// the deleted tagged scope releases the slot of its quota
+		store.DeleteScope(scope.Tagged(map[string]string{"id": "0"}))
+		assert.Equal(t, uint64(1), g.Value())
+		assert.Equal(t, "client.rq_total", scope.Tagged(map[string]string{"id": "12"}).Counter("rq_total").Name())
+	})
+
+	t.Run("rejected names", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetricsPerScope(1))
+		scope := store.CreateScope("client")
+		scope.Counter("c1")
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, "client.__overflow__", scope.Counter("dyn").Name())
+		}
+		// the same name is counted once
+		rejected := store.getStatsScope().Counter("cardinality_rejected")
+		assert.Equal(t, uint64(1), rejected.Value())
+		assert.Equal(t, "client.__overflow__", scope.Gauge("dyn").Name())
+		assert.Equal(t, uint64(2), rejected.Value())
+
+		// the rejected names are forgotten after releasing any slot
+		scope.RemoveCounter("c1")
+		assert.Equal(t, "client.dyn", scope.Counter("dyn").Name())
+	})
+
+	t.Run("children", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetrics(1))
+		root := store.CreateScope("")
+		root.NewChild("client").Counter("c1")
+		overflow := root.NewChild("server")
+		assert.True(t, overflow == root.NewChild("upstream"))
+		assert.Equal(t, "__overflow__", overflow.Counter("c1").Name())
+		assert.Equal(t, 3, len(store.Scopes())) // root, client and stats
+	})
+
+	t.Run("reserved scope", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithMaxMetrics(1))
+		assert.Panics(t, func() { store.CreateScope("stats") })
+		root := store.CreateScope("")
+		assert.Panics(t, func() { root.NewChild("stats.") })
+		assert.Equal(t, "foo.", root.NewChild("foo.").Name())
+	})
+}
+
+func TestStoreMatcher(t *testing.T) {
+	m, err := NewStatsMatcher(nil, []StringMatcher{{Prefix: "debug."}, {Suffix: ".verbose"}})
+	assert.NoError(t, err)
+	sink := new(mockSink)
+	store := NewStore(NewStoreOption().WithMatcher(m).WithSinks(sink))
+
+	debug := store.CreateScope("debug")
+	c := debug.Counter("rq_total")
+	assert.True(t, c == nullCounter)
+	assert.True(t, c == debug.CounterWithTags("rq_total", &Tag{Name: "k", Value: "v"}))
+	c.Inc()
+	assert.Equal(t, uint64(0), c.Value())
+	assert.True(t, debug.Gauge("cx_active") == nullGauge)
+	assert.True(t, debug.FloatGauge("load") == nullFloatGauge)
+	assert.True(t, debug.IntGauge("delta") == nullIntGauge)
+	g := debug.GaugeFunc("size", func() uint64 { return 1 })
+	assert.Equal(t, uint64(0), g.Value())
+	h := debug.Histogram("rq_time")
+	h.Record(1)
+	assert.Zero(t, atomic.LoadInt32(&sink.writeHistSampleCalled))
+
+	upstream := store.CreateScope("upstream")
+	upstream.Counter("rq_total").Inc()
+	upstream.Counter("rq.verbose").Inc()
+	cs := store.Counters()
+	assert.Equal(t, 1, len(cs))
+	assert.Equal(t, "upstream.rq_total", cs[0].Name())
+	assert.Equal(t, 0, len(store.Gauges()))
+	assert.Equal(t, 0, len(store.Histograms()))
+}
+
+func TestStoreFlush(t *testing.T) {
+	sink1 := &closableSink{flushErr: errors.New("sink1 failed")}
+	sink2 := &closableSink{flushErr: errors.New("sink2 failed")}
+	var flushed int
+	sink1.flushCallback = func(snapshot MetricsSnapshot) {
+		flushed++
+		assert.Equal(t, uint64(1), findCounter(snapshot, "foo").IntervalValue())
+	}
+	store := NewStore(NewStoreOption().WithSinks(sink1, sink2))
+	store.CreateScope("").Counter("foo").Inc()
+
+	err := store.Flush()
+	assert.EqualError(t, err, "flush sink sink0: sink1 failed")
+	assert.Equal(t, 1, flushed)
+}
+
+func TestStoreErrors(t *testing.T) {
+	t.Run("buffered", func(t *testing.T) {
+		sink := &closableSink{flushErr: errors.New("failed")}
+		store := NewStore(NewStoreOption().WithSinks(sink).WithErrorBufferSize(1))
+		before := time.Now()
+		store.Flush() //nolint:errcheck
+		store.Flush() //nolint:errcheck
+
+		err := (<-store.Errors()).(*FlushError)
+		assert.Equal(t, "sink0", err.Sink)
+		assert.EqualError(t, err.Err, "failed")
+		assert.False(t, err.Time.Before(before))
+		assert.Equal(t, uint64(1), store.getStatsScope().Counter("errors_dropped").Value())
+	})
+
+	t.Run("cause", func(t *testing.T) {
+		err := newFlushError("sink0", ErrFlushTimeout)
+		assert.Equal(t, ErrFlushTimeout, err.Unwrap())
+		assert.Equal(t, ErrFlushTimeout, pkgerrors.Cause(err))
+		assert.Equal(t, ErrFlushTimeout, pkgerrors.Cause(pkgerrors.Wrap(err, "flush")))
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		sink := &closableSink{flushErr: errors.New("failed")}
+		var errs []error
+		store := NewStore(NewStoreOption().
+			WithSinks(sink).
+			WithErrorHandler(func(err error) {
+				errs = append(errs, err)
+			}))
+		store.Flush() //nolint:errcheck
+		store.Flush() //nolint:errcheck
+
+		assert.Equal(t, 2, len(errs))
+		assert.Equal(t, uint64(0), store.getStatsScope().Counter("errors_dropped").Value())
+	})
+}
+
+func TestStoreSinkLatch(t *testing.T) {
+	sink1 := new(mockSink)
+	sink2 := new(mockSink)
+	store := NewStore(NewStoreOption().WithSinks(sink1))
+	scope := store.CreateScope("")
+	c := scope.Counter("foo")
+	h := scope.Histogram("bar")
+	c.Add(2)
+	h.Record(1)
+	store.AddSinkWithOption(sink2, NewSinkOption().WithFlushInterval(time.Hour))
+
+	var counterVal, sampleCount uint64
+	sink2.flushCallback = func(snapshot MetricsSnapshot) {
+		counterVal = findCounter(snapshot, "foo").IntervalValue()
+		sampleCount = findHistogram(snapshot, "bar").IntervalStatistics().SampleCount()
+	}
+	sink1.flushCallback = func(snapshot MetricsSnapshot) {
+		counterVal = findCounter(snapshot, "foo").IntervalValue()
+		sampleCount = findHistogram(snapshot, "bar").IntervalStatistics().SampleCount()
+	}
+
+	entries := store.sinkEntries()
+	assert.Equal(t, 2, len(entries))
+	assert.NoError(t, store.flushSink(context.Background(), entries[0], false))
+	assert.Equal(t, uint64(2), counterVal)
+	assert.Equal(t, uint64(1), sampleCount)
+
+	// the sink added later counts from the time it's added
+	c.Add(3)
+	h.Record(1)
+	assert.NoError(t, store.flushSink(context.Background(), entries[1], false))
+	assert.Equal(t, uint64(3), counterVal)
+	assert.Equal(t, uint64(1), sampleCount)
+
+	// each sink sees the deltas since its own last flush
+	c.Add(1)
+	assert.NoError(t, store.flushSink(context.Background(), entries[0], false))
+	assert.Equal(t, uint64(4), counterVal)
+	assert.Equal(t, uint64(1), sampleCount)
+	assert.NoError(t, store.flushSink(context.Background(), entries[1], false))
+	assert.Equal(t, uint64(1), counterVal)
+	assert.Equal(t, uint64(0), sampleCount)
+
+	// the snapshot is a read-only copy
+	sink1.flushCallback = func(snapshot MetricsSnapshot) {
+		findCounter(snapshot, "foo").Inc()
+	}
+	assert.NoError(t, store.flushSink(context.Background(), entries[0], false))
+	assert.Equal(t, uint64(6), c.Value())
+}
+
+type blockingSink struct {
+	mockSink
+	unblock chan struct{}
+}
+
+func (sink *blockingSink) Flush(snapshot MetricsSnapshot) error {
+	<-sink.unblock
+	return nil
+}
+
+func (sink *blockingSink) Name() string {
+	return "blocking"
+}
+
+func TestStoreConcurrentFlush(t *testing.T) {
+	slow := &blockingSink{unblock: make(chan struct{})}
+	fast := &closableSink{flushErr: errors.New("fast failed")}
+	var fastFlushed bool
+	fast.flushCallback = func(MetricsSnapshot) {
+		fastFlushed = true
+	}
+	store := NewStore(NewStoreOption().WithFlushInterval(time.Hour))
+	store.AddSinkWithOption(slow, NewSinkOption().WithFlushTimeout(time.Millisecond*50))
+	store.AddSinkWithOption(fast, NewSinkOption().WithName("fast"))
+	store.CreateScope("").Counter("foo").Inc()
+
+	// the slow sink times out without delaying the fast one
+	err := store.Flush()
+	assert.Equal(t, ErrFlushTimeout, err.(*FlushError).Err)
+	assert.Equal(t, "blocking", err.(*FlushError).Sink)
+	assert.True(t, fastFlushed)
+
+	// the slow sink is skipped until its flush returns
+	err = store.Flush()
+	assert.EqualError(t, err, "flush sink blocking: previous flush is still in progress")
+	close(slow.unblock)
+	assert.Eventually(t, func() bool {
+		entry := store.sinkEntries()[0]
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		return !entry.inFlightLocked()
+	}, time.Second, time.Millisecond*10)
+	assert.EqualError(t, store.Flush(), "flush sink fast: fast failed")
+
+	stats := store.getStatsScope()
+	assert.Equal(t, uint64(1), stats.Counter("sink.blocking.flush_timeouts").Value())
+	assert.Equal(t, uint64(3), stats.Counter("sink.fast.flush_errors").Value())
+	assert.NotZero(t, stats.Gauge("sink.blocking.last_success_timestamp").Value())
+	assert.Zero(t, stats.Gauge("sink.fast.last_success_timestamp").Value())
+	stats.Histogram("sink.fast.flush_latency").RefreshIntervalStatistics()
+	assert.Equal(t, uint64(3), stats.Histogram("sink.fast.flush_latency").CumulativeStatistics().SampleCount())
+}
+
+func TestStoreSinkFlushInterval(t *testing.T) {
+	var mu sync.Mutex
+	var fast, slow int
+	sink1 := new(mockSink)
+	sink1.flushCallback = func(MetricsSnapshot) {
+		mu.Lock()
+		fast++
+		mu.Unlock()
+	}
+	sink2 := new(mockSink)
+	sink2.flushCallback = func(MetricsSnapshot) {
+		mu.Lock()
+		slow++
+		mu.Unlock()
+	}
+	store := NewStore(NewStoreOption().WithFlushInterval(time.Hour))
+	store.AddSinkWithOption(sink1, NewSinkOption().WithFlushInterval(time.Millisecond*20))
+	store.AddSinkWithOption(sink2, NewSinkOption().WithFlushInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*150, cancel)
+	store.FlushingLoop(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, fast >= 2)
+	assert.Equal(t, 0, slow)
+}
+
+func TestStoreFlushNow(t *testing.T) {
+	flushed := make(chan struct{}, 1)
+	sink1 := new(mockSink)
+	sink1.flushCallback = func(MetricsSnapshot) {
+		flushed <- struct{}{}
+	}
+	store := NewStore(NewStoreOption().WithFlushInterval(time.Hour))
+	store.AddSinkWithOption(sink1, NewSinkOption().WithFlushInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.FlushingLoop(ctx)
+
+	store.FlushNow()
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("flush now timed out")
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	t.Run("final flush", func(t *testing.T) {
+		sink1 := new(closableSink)
+		sink2 := new(mockSink)
+		var flushed bool
+		sink1.flushCallback = func(snapshot MetricsSnapshot) {
+			flushed = true
+			assert.Equal(t, uint64(1), snapshot.Counters()[0].IntervalValue())
+		}
+		store := NewStore(NewStoreOption().WithFlushInterval(time.Hour).WithSinks(sink1, sink2))
+		store.CreateScope("").Counter("foo").Inc()
+
+		loopDone := make(chan struct{})
+		go func() {
+			store.FlushingLoop(context.Background())
+			close(loopDone)
+		}()
+
+		assert.NoError(t, store.Close(context.Background()))
+		<-loopDone
+		assert.True(t, flushed)
+		assert.True(t, sink1.isClosed())
+
+		// close again
+		assert.NoError(t, store.Close(context.Background()))
+	})
+
+	t.Run("release latches", func(t *testing.T) {
+		store := NewStore(NewStoreOption().WithSinks(new(mockSink), new(mockSink)))
+		h := store.CreateScope("").Histogram("foo")
+		h.Record(1)
+		assert.NoError(t, store.Flush())
+		// the histogram itself and the sinks
+		assert.Equal(t, 3, len(h.pending))
+
+		assert.NoError(t, store.Close(context.Background()))
+		assert.Equal(t, 1, len(h.pending))
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		sink := &closableSink{flushed: make(chan struct{})}
+		defer close(sink.flushed)
+		store := NewStore(NewStoreOption().WithSinks(sink))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, store.Close(ctx))
+		// the sink isn't closed under the in-flight flush
+		assert.False(t, sink.isClosed())
+		sink.flushed <- struct{}{}
+		assert.Eventually(t, sink.isClosed, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("timed out flush", func(t *testing.T) {
+		sink := &closableSink{flushed: make(chan struct{})}
+		store := NewStore(NewStoreOption())
+		store.AddSinkWithOption(sink, NewSinkOption().WithFlushTimeout(time.Millisecond*10))
+
+		// the final flush times out, while the sink is still flushing
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		err := store.Close(ctx)
+		assert.Equal(t, ErrFlushTimeout, err.(*FlushError).Err)
+		assert.False(t, sink.isClosed())
+		close(sink.flushed)
+		assert.Eventually(t, sink.isClosed, time.Second, time.Millisecond*10)
+		assert.EqualError(t, store.Flush(), "flush sink sink0: sink is closed")
+	})
+
+	t.Run("in-flight flush", func(t *testing.T) {
+		sink := &closableSink{flushed: make(chan struct{})}
+		var flushes int32
+		sink.flushCallback = func(snapshot MetricsSnapshot) {
+			atomic.AddInt32(&flushes, 1)
+		}
+		store := NewStore(NewStoreOption())
+		store.AddSinkWithOption(sink, NewSinkOption().WithFlushTimeout(time.Millisecond*10))
+		assert.Equal(t, ErrFlushTimeout, store.Flush().(*FlushError).Err)
+
+		// the final flush starts once the in-flight one returns
+		closed := make(chan error, 1)
+		go func() { closed <- store.Close(context.Background()) }()
+		time.Sleep(time.Millisecond * 20)
+		close(sink.flushed)
+		assert.NoError(t, <-closed)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&flushes))
+		assert.True(t, sink.isClosed())
+	})
+
+	t.Run("in-flight flush deadline exceeded", func(t *testing.T) {
+		sink := &closableSink{flushed: make(chan struct{})}
+		defer close(sink.flushed)
+		store := NewStore(NewStoreOption())
+		store.AddSinkWithOption(sink, NewSinkOption().WithFlushTimeout(time.Millisecond*10))
+		assert.Equal(t, ErrFlushTimeout, store.Flush().(*FlushError).Err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, store.Close(ctx))
+		assert.False(t, sink.isClosed())
+	})
+}
+
 func TestStoreDefaultTags(t *testing.T) {
 	store := NewStore(nil)
 	store.SetTagOption(
@@ -3,6 +3,7 @@ package stats
 import (
 	"bytes"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -12,6 +13,80 @@ type Tag struct {
 	Value string
 }
 
+func tagsFromMap(m map[string]string) []*Tag {
+	tags := make([]*Tag, 0, len(m))
+	for name, value := range m {
+		tags = append(tags, &Tag{Name: name, Value: value})
+	}
+	return tags
+}
+
+func sortTags(tags []*Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+}
+
+// mergeTags returns a new slice consisting of base and extra, the tag in
+// extra takes precedence when both of them have the same name.
+func mergeTags(base, extra []*Tag) []*Tag {
+	if len(extra) == 0 {
+		return base
+	}
+	tags := make([]*Tag, len(base), len(base)+len(extra))
+	copy(tags, base)
+	for _, tag := range extra {
+		replaced := false
+		for i := range tags {
+			if tags[i].Name == tag.Name {
+				tags[i] = tag
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// tagEscaper escapes the delimiters of tags text with backslash, which keeps
+// the distinct tags from colliding, e.g. {a=b,c=d} and {a=b\,c=d}.
+var tagEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`, `{`, `\{`, `}`, `\}`)
+
+// tagsString returns the canonical text of tags, e.g. {tag1=foo,tag2=bar}.
+// The delimiters in the names and values are escaped with backslash.
+func tagsString(tags []*Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, tag := range tags {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(tagEscaper.Replace(tag.Name))
+		buf.WriteByte('=')
+		buf.WriteString(tagEscaper.Replace(tag.Value))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+// metricKey returns the sorted copy of tags and the identity of the metric
+// which is composed by the name and tags.
+func metricKey(name string, tags []*Tag) ([]*Tag, string) {
+	if len(tags) == 0 {
+		return nil, name
+	}
+	sorted := make([]*Tag, len(tags))
+	copy(sorted, tags)
+	sortTags(sorted)
+	return sorted, name + tagsString(sorted)
+}
+
 // TagExtractor is used to extract tags from stats name.
 type TagExtractor struct {
 	name        string
@@ -19,11 +19,7 @@ func NewTagOption() *TagOption {
 }
 
 func (t TagOption) defaultTags() []*Tag {
-	var tags = make([]*Tag, 0, len(t.DefaultTags))
-	for k, v := range t.DefaultTags {
-		tags = append(tags, &Tag{k, v})
-	}
-	return tags
+	return tagsFromMap(t.DefaultTags)
 }
 
 // WithDefaultTags sets default tags.
@@ -104,3 +104,34 @@ func TestProduceTags(t *testing.T) {
 	assert.Equal(t, tags[3].Name, "redis_cmd")
 	assert.Equal(t, tags[3].Value, "get")
 }
+
+func TestMergeTags(t *testing.T) {
+	base := []*Tag{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}
+	assert.Equal(t, base, mergeTags(base, nil))
+
+	merged := mergeTags(base, []*Tag{{Name: "b", Value: "3"}, {Name: "c", Value: "4"}})
+	assert.Equal(t, []*Tag{{Name: "a", Value: "1"}, {Name: "b", Value: "3"}, {Name: "c", Value: "4"}}, merged)
+	// the base must not be modified
+	assert.Equal(t, "2", base[1].Value)
+}
+
+func TestMetricKey(t *testing.T) {
+	tags, key := metricKey("rq_total", nil)
+	assert.Nil(t, tags)
+	assert.Equal(t, "rq_total", key)
+
+	tags, key = metricKey("rq_total", []*Tag{{Name: "code", Value: "200"}, {Name: "cluster", Value: "foo"}})
+	assert.Equal(t, "cluster", tags[0].Name)
+	assert.Equal(t, "code", tags[1].Name)
+	assert.Equal(t, "rq_total{cluster=foo,code=200}", key)
+}
+
+func TestMetricKeyEscaping(t *testing.T) {
+	_, key1 := metricKey("rq_total", []*Tag{{Name: "a", Value: "b,c=d"}})
+	_, key2 := metricKey("rq_total", []*Tag{{Name: "a", Value: "b"}, {Name: "c", Value: "d"}})
+	assert.NotEqual(t, key1, key2)
+	assert.Equal(t, `rq_total{a=b\,c\=d}`, key1)
+
+	_, key1 = metricKey("rq_total", []*Tag{{Name: "a", Value: `b\`}, {Name: "c", Value: "}"}})
+	assert.Equal(t, `rq_total{a=b\\,c=\}}`, key1)
+}
@@ -0,0 +1,37 @@
+package stats
+
+import "time"
+
+// Timer is a Histogram recording durations, it's in milliseconds unless
+// the underlying histogram was created with another unit.
+type Timer struct {
+	h *Histogram
+}
+
+// Start returns a Stopwatch started at now.
+func (t Timer) Start() Stopwatch {
+	return Stopwatch{start: time.Now(), h: t.h}
+}
+
+// RecordDuration records the duration to the Timer.
+func (t Timer) RecordDuration(d time.Duration) {
+	t.h.RecordDuration(d)
+}
+
+// Histogram returns the underlying Histogram.
+func (t Timer) Histogram() *Histogram {
+	return t.h
+}
+
+// Stopwatch measures the duration since it started.
+type Stopwatch struct {
+	start time.Time
+	h     *Histogram
+}
+
+// Stop records the duration since the Stopwatch started, and returns it.
+func (sw Stopwatch) Stop() time.Duration {
+	d := time.Since(sw.start)
+	sw.h.RecordDuration(d)
+	return d
+}
@@ -0,0 +1,27 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimer(t *testing.T) {
+	store := NewStore(NewStoreOption().WithFlushInterval(time.Minute))
+	scope := newScope("rq.", store)
+	timer := scope.Timer("latency")
+	assert.Equal(t, UnitMilliseconds, timer.Histogram().Unit())
+	assert.Equal(t, "rq.latency", timer.Histogram().Name())
+
+	timer.RecordDuration(time.Second)
+	sw := timer.Start()
+	time.Sleep(time.Millisecond * 10)
+	assert.True(t, sw.Stop() >= time.Millisecond*10)
+
+	h := timer.Histogram()
+	h.RefreshIntervalStatistics()
+	s := h.IntervalStatistics()
+	assert.Equal(t, uint64(2), s.SampleCount())
+	assert.True(t, s.SampleSum() >= 1010)
+}
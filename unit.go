@@ -0,0 +1,81 @@
+package stats
+
+import "time"
+
+// Unit is the unit of the values recorded by a histogram.
+type Unit int
+
+// The supported units.
+const (
+	UnitNone Unit = iota
+	UnitNanoseconds
+	UnitMicroseconds
+	UnitMilliseconds
+	UnitSeconds
+	UnitBytes
+)
+
+var unitNames = map[Unit]string{
+	UnitNone:         "",
+	UnitNanoseconds:  "nanoseconds",
+	UnitMicroseconds: "microseconds",
+	UnitMilliseconds: "milliseconds",
+	UnitSeconds:      "seconds",
+	UnitBytes:        "bytes",
+}
+
+// the durations of one value in the time units.
+var unitDurations = map[Unit]time.Duration{
+	UnitNanoseconds:  time.Nanosecond,
+	UnitMicroseconds: time.Microsecond,
+	UnitMilliseconds: time.Millisecond,
+	UnitSeconds:      time.Second,
+}
+
+// String returns the name of unit, e.g. milliseconds, it's empty for UnitNone.
+func (u Unit) String() string {
+	return unitNames[u]
+}
+
+// IsTime reports whether the unit is a unit of time.
+func (u Unit) IsTime() bool {
+	_, ok := unitDurations[u]
+	return ok
+}
+
+// Base returns the base unit which the values should be exposed in, it's
+// seconds for the time units and the unit itself for the others.
+func (u Unit) Base() Unit {
+	if u.IsTime() {
+		return UnitSeconds
+	}
+	return u
+}
+
+// ToBase converts the value in the unit to the base unit.
+func (u Unit) ToBase(v float64) float64 {
+	if !u.IsTime() {
+		return v
+	}
+	return v * float64(unitDurations[u]) / float64(time.Second)
+}
+
+// Duration converts the value in the time unit to time.Duration, the values
+// of the other units are regarded as milliseconds.
+func (u Unit) Duration(v float64) time.Duration {
+	d, ok := unitDurations[u]
+	if !ok {
+		d = time.Millisecond
+	}
+	return time.Duration(v * float64(d))
+}
+
+// FromDuration converts the duration to the value in the time unit, the
+// values of the other units are regarded as milliseconds.
+func (u Unit) FromDuration(d time.Duration) float64 {
+	ud, ok := unitDurations[u]
+	if !ok {
+		ud = time.Millisecond
+	}
+	return float64(d) / float64(ud)
+}
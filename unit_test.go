@@ -0,0 +1,27 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnit(t *testing.T) {
+	assert.Equal(t, "", UnitNone.String())
+	assert.Equal(t, "milliseconds", UnitMilliseconds.String())
+	assert.Equal(t, "bytes", UnitBytes.String())
+
+	assert.True(t, UnitNanoseconds.IsTime())
+	assert.False(t, UnitBytes.IsTime())
+	assert.Equal(t, UnitSeconds, UnitMicroseconds.Base())
+	assert.Equal(t, UnitBytes, UnitBytes.Base())
+
+	assert.Equal(t, 1.5, UnitMilliseconds.ToBase(1500))
+	assert.Equal(t, 1500.0, UnitBytes.ToBase(1500))
+
+	assert.Equal(t, time.Microsecond*3, UnitMicroseconds.Duration(3))
+	assert.Equal(t, time.Millisecond*3, UnitNone.Duration(3))
+	assert.Equal(t, 2000.0, UnitMicroseconds.FromDuration(time.Millisecond*2))
+	assert.Equal(t, 2.0, UnitBytes.FromDuration(time.Millisecond*2))
+}
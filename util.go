@@ -15,6 +15,10 @@ func CollectRuntimeMetrics(ctx context.Context, store *Store, interval time.Dura
 	scope := store.CreateScope("runtime.")
 	defer store.DeleteScope(scope)
 
+	scope.GaugeFunc("num_goroutines", func() uint64 {
+		return uint64(runtime.NumGoroutine())
+	})
+
 	var lastNumGC uint32
 	for {
 		select {
@@ -44,12 +48,13 @@ func CollectRuntimeMetrics(ctx context.Context, store *Store, interval time.Dura
 			lastNumGC = numGC - 255
 		}
 		for i := lastNumGC; i < numGC; i++ {
-			pause := memStats.PauseNs[i%256] / uint64(time.Millisecond)
-			scope.Histogram("gc_pause_ms").Record(pause)
+			// keep the name and unitless milliseconds which the existing
+			// dashboards rely on, a Timer would be renamed in exposition.
+			pause := float64(memStats.PauseNs[i%256]) / float64(time.Millisecond)
+			scope.Histogram("gc_pause_ms").RecordFloat(pause)
 		}
 		lastNumGC = numGC
 
-		scope.Gauge("num_goroutines").Set(uint64(runtime.NumGoroutine()))
 		scope.Gauge("gc_pause_total_ms").Set(memStats.PauseTotalNs / uint64(time.Millisecond))
 		scope.Gauge("alloc_bytes").Set(memStats.Alloc)
 		scope.Gauge("total_alloc_bytes").Set(memStats.TotalAlloc)